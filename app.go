@@ -1,6 +1,7 @@
 package ngrpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -10,8 +11,12 @@ import (
 	"os"
 	"os/signal"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ayonli/goext"
 	"github.com/ayonli/goext/collections"
@@ -20,8 +25,11 @@ import (
 	"github.com/ayonli/ngrpc/config"
 	"github.com/ayonli/ngrpc/pm"
 	"github.com/ayonli/ngrpc/util"
+	"github.com/bufbuild/protocompile/linker"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var theApp *RpcApp
@@ -56,6 +64,23 @@ type ServableService interface {
 	Serve(s grpc.ServiceRegistrar)
 }
 
+// InitializableService represents a service struct that implements the `Init()` method. The method
+// is called after the server starts serving and the client connections are ready, which can be used
+// to warm up the service, for example, establishing database connections. The app is reported ready
+// to the host server (and the CLI) only after all the services' `Init()` methods succeed.
+//
+// If the app's `startTimeout` is set, the context will be canceled once the timeout is reached.
+type InitializableService interface {
+	Init(ctx context.Context) error
+}
+
+// debugLog prints the message only when the `NGRPC_DEBUG` environment variable is set.
+func debugLog(format string, args ...any) {
+	if os.Getenv("NGRPC_DEBUG") != "" {
+		log.Printf(format, args...)
+	}
+}
+
 func getServiceName[T any](service ConnectableService[T]) string {
 	return reflect.TypeOf(service).String()[1:]
 }
@@ -97,9 +122,11 @@ func StartWithConfig(appName string, cfg config.Config) (*RpcApp, error) {
 		}
 
 		var app *RpcApp
+		// Expand the replicas in case the config isn't loaded from the config file.
+		apps := goext.Ok(config.ExpandApps(cfg.Apps))
 
 		if appName != "" {
-			cfgApp, ok := slicex.Find(cfg.Apps, func(item config.App, _ int) bool {
+			cfgApp, ok := slicex.Find(apps, func(item config.App, _ int) bool {
 				return item.Name == appName
 			})
 
@@ -107,18 +134,18 @@ func StartWithConfig(appName string, cfg config.Config) (*RpcApp, error) {
 				panic(fmt.Errorf("app [%s] is not configured", appName))
 			}
 
-			app = &RpcApp{App: cfgApp}
+			app = &RpcApp{App: cfgApp, protoPaths: cfg.ProtoPaths}
 
 			// Initiate the server if the app is set to serve.
 			if cfgApp.Serve && len(cfgApp.Services) > 0 {
 				goext.Ok(0, app.initServer())
 			}
 		} else {
-			app = &RpcApp{}
+			app = &RpcApp{protoPaths: cfg.ProtoPaths}
 		}
 
 		// Initiate client connections for all apps.
-		goext.Ok(0, app.initClient(cfg.Apps))
+		goext.Ok(0, app.initClient(apps))
 
 		theApp = app
 
@@ -129,9 +156,27 @@ func StartWithConfig(appName string, cfg config.Config) (*RpcApp, error) {
 			app.guest = pm.NewGuest(app.App, func(msgId string) {
 				app.stop(msgId, true)
 			})
+			app.guest.OnDrain(app.drain)
 			app.guest.Join()
 		}
 
+		if err := app.initServices(); err != nil {
+			// Don't leave the group gracefully, so the host server treats it as a crash and
+			// restarts the app according to its restart policy once the process exits.
+			app.stop("", false)
+			panic(err)
+		}
+
+		if app.health != nil {
+			app.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+		}
+
+		if app.guest != nil {
+			app.guest.Ready()
+		}
+
+		app.notifyReady()
+
 		return app
 	})
 
@@ -187,7 +232,6 @@ func GetServiceClient[T any](service ConnectableService[T], route string) (T, er
 		}
 
 		record, ok := theApp.remoteServices.Get(serviceName)
-		var ins T
 
 		if !ok {
 			dialers, ok := theApp.serviceDialers.Get(serviceName)
@@ -196,87 +240,121 @@ func GetServiceClient[T any](service ConnectableService[T], route string) (T, er
 				panic(fmt.Errorf("service %s is not registered", serviceName))
 			}
 
+			if !structx.HasMethod(service, "Connect") {
+				panic(fmt.Errorf("service %s doesn't implement the Connect() method", serviceName))
+			}
+
+			record = &remoteService{
+				instances: []remoteInstance{},
+				counter:   0, // initiate the counter
+			}
+
+			// Dial all the apps (including replicas) that serve the service, so the traffic can be
+			// balanced between them.
 			for _, entry := range dialers {
 				// Dial the server on demand.
 				conn := goext.Ok(entry.dial())
 
-				if structx.HasMethod(service, "Connect") {
-					// Calls the service's Connect() method to bind connection and gain the service
-					// client.
-					returns := structx.CallMethod(service, "Connect", conn)
-					instance := returns[0]
-					record = &remoteService{
-						instances: []remoteInstance{
-							{
-								app:      entry.app.Name,
-								url:      entry.app.Url,
-								conn:     conn,
-								instance: instance,
-							},
-						},
-						counter: 0, // initiate the counter
-					}
-
-					// Store the instance (service client) in the unified collection for future use.
-					theApp.remoteServices.Set(serviceName, record)
-					break
-				}
+				// Calls the service's Connect() method to bind connection and gain the service
+				// client.
+				returns := structx.CallMethod(service, "Connect", conn)
+				record.instances = append(record.instances, remoteInstance{
+					app:      entry.app.Name,
+					url:      entry.app.Url,
+					conn:     conn,
+					instance: returns[0],
+				})
 			}
+
+			// Store the instance (service client) in the unified collection for future use.
+			theApp.remoteServices.Set(serviceName, record)
 		}
 
-		// Use only the active instances.
-		instances := slicex.Filter(record.instances, func(item remoteInstance, idx int) bool {
-			return item.conn.GetState() != connectivity.Shutdown
-		})
+		instance, ok := record.pick(route)
 
-		if len(instances) == 0 {
+		if !ok {
 			panic(fmt.Errorf("service %s is not available", serviceName))
 		}
 
-		if route != "" { // If route is set:
-			matched := false
+		return instance.instance.(T)
+	})
+}
 
-			// First, try to match the route directly against the services' uris, if match any,
-			// return it respectively.
-			for _, item := range instances {
-				if item.app == route || item.url == route {
-					ins = item.instance.(T)
-					matched = true
-					break
-				}
-			}
+// pick selects an active instance according to the `route`, see `GetServiceClient()` for how the
+// route is used.
+func (self *remoteService) pick(route string) (remoteInstance, bool) {
+	// Use only the active instances, and skip the drained ones.
+	instances := slicex.Filter(self.instances, func(item remoteInstance, idx int) bool {
+		return item.conn.GetState() != connectivity.Shutdown && !isDrained(item.app)
+	})
 
-			if !matched {
-				// Then, try to use the hash algorithm to retrieve a remote instance.
-				idx := util.Hash(route) % len(instances)
-				ins = instances[idx].instance.(T)
-			}
+	if len(instances) == 0 {
+		return remoteInstance{}, false
+	}
+
+	var ins remoteInstance
+
+	if route != "" { // If route is set:
+		// First, try to match the route directly against the services' uris, if match any,
+		// return it respectively. If the route is the base name of replicas, use round-robin
+		// algorithm against them.
+		matches := slicex.Filter(instances, func(item remoteInstance, idx int) bool {
+			return config.MatchApp(item.app, route) || item.url == route
+		})
+
+		if len(matches) > 0 {
+			ins = matches[self.counter%len(matches)]
 		} else {
-			// Use round-robin algorithm by default.
-			idx := record.counter % len(instances)
-			ins = instances[idx].instance.(T)
+			// Then, try to use the hash algorithm to retrieve a remote instance.
+			idx := util.Hash(route) % len(instances)
+			ins = instances[idx]
 		}
+	} else {
+		// Use round-robin algorithm by default.
+		idx := self.counter % len(instances)
+		ins = instances[idx]
+	}
 
-		// Increase the service`s counter every time.
-		record.counter++
-		if record.counter == int(math.Pow(2, 32)) { // reset counter when it's too big
-			record.counter = 0
-		}
+	// Increase the service`s counter every time.
+	self.counter++
+	if self.counter == int(math.Pow(2, 32)) { // reset counter when it's too big
+		self.counter = 0
+	}
 
-		return ins
-	})
+	return ins, true
+}
+
+// isDrained reports whether the app instance has been drained by the `ngrpc drain` command.
+func isDrained(appName string) bool {
+	app := theApp
+	return app != nil && app.guest != nil && app.guest.IsDrained(appName)
 }
 
 // RpcApp is used both to configure the apps and hold the app instance.
 type RpcApp struct {
 	config.App
 	server         *grpc.Server
+	health         *health.Server
 	clients        *collections.Map[string, *grpc.ClientConn]
 	services       []ServableService
 	remoteServices *collections.Map[string, *remoteService]
 	serviceDialers *collections.Map[string, []dialer]
 	locks          *collections.Map[string, *sync.Mutex]
 	guest          *pm.Guest
+	// The number of calls that the server is handling, used to wait for them when draining.
+	inflight     int
+	inflightLock sync.Mutex
+	// Closed once there are no calls in flight, created by the waiter, see `waitForCalls()`.
+	idle chan struct{}
+	// Closed to stop pinging the service manager's watchdog.
+	watchdogStop chan bool
+
+	// The following fields are used by `Invoke()` to call the services dynamically.
+	protoPaths      []string
+	protoFiles      linker.Files
+	protoErr        error
+	protoLock       sync.Mutex
+	dynamicServices *collections.Map[string, *remoteService]
 
 	// Whether this app will keep the process alive, will be set true once `WaitForExit()` is called.
 	isProcessKeeper bool
@@ -296,7 +374,11 @@ func (self *RpcApp) initServer() error {
 		cred := goext.Ok(config.GetCredentials(self.App, urlObj))
 
 		// Initiate the gRPC server
-		self.server = grpc.NewServer(grpc.Creds(cred))
+		self.server = grpc.NewServer(
+			grpc.Creds(cred),
+			grpc.ChainUnaryInterceptor(self.trackUnaryCall),
+			grpc.ChainStreamInterceptor(self.trackStreamCall),
+		)
 		self.services = []ServableService{}
 
 		for _, serviceName := range self.Services {
@@ -342,11 +424,26 @@ func (self *RpcApp) initServer() error {
 			}
 		}
 
+		// Register the standard health service, which is probed by the host server, the app
+		// reports serving only after its services are initiated.
+		self.health = health.NewServer()
+		self.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		healthpb.RegisterHealthServer(self.server, self.health)
+
 		tcpSrv := goext.Ok(net.Listen("tcp", addr))
 
+		if urlObj.Port() == "0" {
+			// The port is allocated by the system, update the URL so the actual address will be
+			// reported to the host server and can be resolved by the clients.
+			port := tcpSrv.Addr().(*net.TCPAddr).Port
+			urlObj.Host = net.JoinHostPort(urlObj.Hostname(), strconv.Itoa(port))
+			self.Url = urlObj.String()
+		}
+
 		// Start the server in another goroutine to prevent blocking.
 		go func() {
-			if err := self.server.Serve(tcpSrv); err != nil {
+			// The server may be stopped before it starts serving if the app fails to start.
+			if err := self.server.Serve(tcpSrv); err != nil && err != grpc.ErrServerStopped {
 				log.Fatal(err)
 			}
 		}()
@@ -357,6 +454,116 @@ func (self *RpcApp) initServer() error {
 	return err
 }
 
+func (self *RpcApp) trackUnaryCall(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	self.beginCall()
+	defer self.endCall()
+	return handler(ctx, req)
+}
+
+func (self *RpcApp) trackStreamCall(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if strings.HasPrefix(info.FullMethod, "/grpc.health.v1.Health/") {
+		// `Watch` streams live as long as the clients want, they're not waited when draining.
+		return handler(srv, ss)
+	}
+
+	self.beginCall()
+	defer self.endCall()
+	return handler(srv, ss)
+}
+
+func (self *RpcApp) beginCall() {
+	self.inflightLock.Lock()
+	defer self.inflightLock.Unlock()
+	self.inflight++
+}
+
+func (self *RpcApp) endCall() {
+	self.inflightLock.Lock()
+	defer self.inflightLock.Unlock()
+	self.inflight--
+
+	if self.inflight == 0 && self.idle != nil {
+		close(self.idle)
+		self.idle = nil
+	}
+}
+
+// waitForCalls waits until there are no calls in flight, or the `ctx` is done.
+func (self *RpcApp) waitForCalls(ctx context.Context) error {
+	self.inflightLock.Lock()
+
+	if self.inflight == 0 {
+		self.inflightLock.Unlock()
+		return nil
+	} else if self.idle == nil {
+		self.idle = make(chan struct{})
+	}
+
+	idle := self.idle
+	self.inflightLock.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain handles the `drain` and `undrain` commands sent by the CLI. When draining, the health
+// status is set to NOT_SERVING, so the load balancers stop routing traffic to the app, and it waits
+// for the in-flight calls to finish until the `ctx` is done.
+func (self *RpcApp) drain(ctx context.Context, drain bool) error {
+	if self.health == nil {
+		return nil
+	} else if !drain {
+		self.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+		log.Printf("app [%s] undrained", self.Name)
+		return nil
+	}
+
+	self.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	if err := self.waitForCalls(ctx); err != nil {
+		log.Printf("app [%s] didn't finish the in-flight calls in time", self.Name)
+		return err
+	}
+
+	log.Printf("app [%s] drained", self.Name)
+	return nil
+}
+
+// initServices calls the `Init()` method of the services that implement it.
+func (self *RpcApp) initServices() error {
+	ctx := context.Background()
+
+	if self.StartTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(self.StartTimeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	for i, service := range self.services {
+		if ins, ok := service.(InitializableService); ok {
+			if err := ins.Init(ctx); err != nil {
+				return fmt.Errorf("unable to initiate service [%s]: %w", self.Services[i], err)
+			}
+		}
+	}
+
+	return nil
+}
+
 // initClient initiates gRPC connections and binds client services for all the apps.
 func (self *RpcApp) initClient(apps []config.App) error {
 	_, err := goext.Try(func() int {
@@ -364,8 +571,20 @@ func (self *RpcApp) initClient(apps []config.App) error {
 		self.remoteServices = &collections.Map[string, *remoteService]{}
 		self.serviceDialers = &collections.Map[string, []dialer]{}
 		self.locks = &collections.Map[string, *sync.Mutex]{}
+		self.dynamicServices = &collections.Map[string, *remoteService]{}
 
 		slicex.ForEach(apps, func(app config.App, idx int) {
+			baseName := config.GetBaseName(app.Name)
+
+			if self.Dependencies != nil &&
+				baseName != config.GetBaseName(self.Name) &&
+				!slices.Contains(self.Dependencies, baseName) {
+				// If the app declares its dependencies, only connect to the apps it depends on,
+				// so the program doesn't need to register the services it never uses.
+				debugLog("app [%s] is not a dependency of app [%s], skipped", app.Name, self.Name)
+				return
+			}
+
 			urlObj := goext.Ok(url.Parse(app.Url))
 
 			var addr string
@@ -391,7 +610,23 @@ func (self *RpcApp) initClient(apps []config.App) error {
 						return conn
 					}
 
-					conn = goext.Ok(grpc.Dial(addr, grpc.WithTransportCredentials(cred)))
+					target := addr
+					opts := []grpc.DialOption{grpc.WithTransportCredentials(cred)}
+
+					if config.HasDynamicPort(app.Url) {
+						if app.Name == self.Name {
+							// The app is the current one, use the port it has bound.
+							target = config.GetAddress(goext.Ok(url.Parse(self.Url)))
+						} else {
+							// The app is served on a dynamic port, use the `ngrpc:` resolver to
+							// resolve the actual address from the host server, and keep it updated
+							// when the app restarts.
+							target = "ngrpc://" + app.Name
+							opts = append(opts, grpc.WithAuthority(urlObj.Hostname()))
+						}
+					}
+
+					conn = goext.Ok(grpc.Dial(target, opts...))
 					self.clients.Set(app.Name, conn)
 
 					return conn
@@ -400,7 +635,12 @@ func (self *RpcApp) initClient(apps []config.App) error {
 
 			slicex.ForEach(app.Services, func(serviceName string, _ int) {
 				if !serviceStore.Has(serviceName) {
-					panic(fmt.Errorf("service [%s] hasn't been registered", serviceName))
+					// A service that isn't registered can still be called via `Invoke()`, as long
+					// as it's defined in the proto files.
+					if _, err := self.findServiceDescriptor(serviceName); err != nil {
+						debugLog("%v", err)
+						panic(fmt.Errorf("service [%s] hasn't been registered", serviceName))
+					}
 				}
 
 				entries, ok := self.serviceDialers.Get(serviceName)
@@ -438,6 +678,8 @@ func (self *RpcApp) Stop() {
 }
 
 func (self *RpcApp) stop(msgId string, graceful bool) {
+	self.notifyStopping()
+
 	if self.clients != nil {
 		self.clients.ForEach(func(conn *grpc.ClientConn, _ string) {
 			conn.Close()
@@ -452,6 +694,10 @@ func (self *RpcApp) stop(msgId string, graceful bool) {
 			}
 		}
 
+		if self.health != nil {
+			self.health.Shutdown()
+		}
+
 		self.server.Stop()
 	}
 
@@ -485,6 +731,46 @@ func (self *RpcApp) stop(msgId string, graceful bool) {
 	}
 }
 
+// notifyReady tells the service manager (e.g. systemd) that the app is ready, and starts pinging
+// its watchdog if it's enabled, see `util.SdNotify()`.
+func (self *RpcApp) notifyReady() {
+	if ok, err := util.SdNotify("READY=1"); err != nil {
+		log.Printf("unable to notify the service manager: %v", err)
+		return
+	} else if !ok {
+		return
+	}
+
+	if timeout := util.SdWatchdogTimeout(); timeout > 0 {
+		self.watchdogStop = make(chan bool)
+		go self.pingWatchdog(timeout/2, self.watchdogStop)
+	}
+}
+
+func (self *RpcApp) pingWatchdog(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			util.SdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+// notifyStopping tells the service manager that the app is stopping, and stops the watchdog.
+func (self *RpcApp) notifyStopping() {
+	if self.watchdogStop != nil {
+		close(self.watchdogStop)
+		self.watchdogStop = nil
+	}
+
+	util.SdNotify("STOPPING=1")
+}
+
 // OnStop registers a callback to run after the app is stopped.
 func (self *RpcApp) OnStop(callback func()) {
 	self.onStop = callback
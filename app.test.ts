@@ -23,6 +23,32 @@ test("ngrpc.localConfig with local config file", async () => {
     assert.ok(config.apps.length > 0);
 });
 
+test("ngrpc.loadConfig with replicas", async () => {
+    const conf = JSON.parse(await fs.readFile("ngrpc.json", "utf8")) as Config;
+    conf.apps = [
+        { name: "replica-server", url: "grpc://localhost:{4000+i}", instances: 2, services: [] },
+        { name: "single-server", url: "grpc://localhost:{5000+i}", services: [] },
+    ];
+    await fs.writeFile("ngrpc.local.json", JSON.stringify(conf));
+    const [err, config] = await _try<Error, Config>(ngrpc.loadConfig());
+
+    conf.apps = [
+        { name: "invalid-server", url: "grpc://localhost:4000", instances: 2, services: [] },
+    ];
+    await fs.writeFile("ngrpc.local.json", JSON.stringify(conf));
+    const [err2] = await _try<Error, Config>(ngrpc.loadConfig());
+    await fs.unlink("ngrpc.local.json");
+
+    assert.ok(!err);
+    assert.deepStrictEqual(config.apps.map(app => [app.name, app.url]), [
+        ["replica-server#0", "grpc://localhost:4000"],
+        ["replica-server#1", "grpc://localhost:4001"],
+        ["single-server", "grpc://localhost:5000"],
+    ]);
+    assert.strictEqual(err2?.message, "app [invalid-server] has multiple instances "
+        + "but its URL is neither a template nor using port 0");
+});
+
 test("ngrpc.loadConfig with failure", async () => {
     await fs.rename("ngrpc.json", "ngrpc.jsonc");
     const [err, config] = await _try<Error, Config>(ngrpc.loadConfig());
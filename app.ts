@@ -65,8 +65,22 @@ export interface App {
     stderr?: string;
     entry?: string;
     env?: { [name: string]: string; };
+    /**
+     * The number of replicas of this app, each replica is named `<name>#<i>`, and the `url` shall
+     * use a port template, e.g. `grpc://localhost:{4000+i}`.
+     */
+    instances?: number;
     connectTimeout?: number;
     options?: ChannelOptions;
+    /** The options of the heartbeats between the host server and the app. */
+    heartbeat?: {
+        /** The interval in milliseconds between the heartbeats, a negative value disables them. */
+        interval?: number;
+        /** The number of heartbeats missed in a row before the peer is considered unresponsive. */
+        maxMisses?: number;
+        /** Kill and respawn the app once it's unresponsive. */
+        restart?: boolean;
+    };
 }
 
 const defaultApp: App = {
@@ -81,8 +95,10 @@ const defaultApp: App = {
     stderr: undefined,
     entry: undefined,
     env: undefined,
+    instances: undefined,
     connectTimeout: undefined,
     options: undefined,
+    heartbeat: undefined,
 };
 Object.seal(defaultApp);
 
@@ -153,6 +169,7 @@ export class RpcApp implements App {
     stderr?: string | undefined;
     entry?: string | undefined;
     env?: { [name: string]: string; } | undefined;
+    instances?: number | undefined;
     connectTimeout?: number | undefined;
     options?: ChannelOptions | undefined;
     private pkgDef: GrpcObject | null = null;
@@ -189,6 +206,8 @@ export class RpcApp implements App {
             });
         }
 
+        conf.apps = expandApps(conf.apps ?? []);
+
         if (conf.protoDirs && !conf.protoPaths) {
             conf.protoPaths = conf.protoDirs;
             delete conf.protoDirs;
@@ -327,7 +346,7 @@ export class RpcApp implements App {
      * Like `start()` except it takes a config argument instead of loading the config file.
      */
     static async startWithConfig(appName: string | null, config: Config) {
-        return await this._start(appName, config);
+        return await this._start(appName, { ...config, apps: expandApps(config.apps) });
     }
 
     private static async _start(appName: string | null, config: Config, once = false) {
@@ -343,6 +362,10 @@ export class RpcApp implements App {
 
             if (!cfgApp) {
                 throw new Error(`app [${appName}] is not configured`);
+            } else if (cfgApp.serve && hasDynamicPort(cfgApp.url)) {
+                // The actual URL must be reported to the host server, which only the Golang
+                // programs do.
+                throw new Error(`app [${appName}] uses port 0, which is not supported in Node.js`);
             }
 
             Object.assign(app, cfgApp);
@@ -376,6 +399,10 @@ export class RpcApp implements App {
                 },
             });
             await app.guest.join();
+
+            // The services' `init()` methods have been called when initiating the server, the app
+            // is ready to serve now.
+            app.guest.ready();
         }
 
         return app;
@@ -420,9 +447,10 @@ export class RpcApp implements App {
             throw new Error(`service ${serviceName} is not registered`);
         }
 
+        // Use only the active instances, and skip the drained ones.
         const instances = remoteService.instances.filter(item => {
             const state = item.client.getChannel().getConnectivityState(false);
-            return state != connectivityState.SHUTDOWN;
+            return state != connectivityState.SHUTDOWN && !this.theApp?.guest?.isDrained(item.app);
         });
 
         if (!instances.length) {
@@ -953,6 +981,54 @@ export class RpcApp implements App {
 const ngrpc = RpcApp;
 export default ngrpc;
 
+const portTemplate = /\{\s*(?:(\d+)\s*\+\s*)?i\s*\}/g;
+
+/**
+ * Expands the apps that have multiple `instances` into replicas, each replica is named
+ * `<name>#<i>` and has its URL port evaluated from the template, the same as the Golang program.
+ *
+ * This function is idempotent, the replicas will not be expanded again.
+ */
+function expandApps(apps: App[]): App[] {
+    const result: App[] = [];
+
+    for (const app of apps) {
+        if (!app.instances || app.instances <= 1) {
+            result.push({ ...app, instances: undefined, url: renderUrl(app.url, 0) });
+            continue;
+        } else if (!app.url.match(portTemplate) && !hasDynamicPort(app.url)) {
+            throw new Error(`app [${app.name}] has multiple instances `
+                + `but its URL is neither a template nor using port 0`);
+        }
+
+        for (let i = 0; i < app.instances; i++) {
+            result.push({
+                ...app,
+                name: `${app.name}#${i}`,
+                url: renderUrl(app.url, i),
+                instances: undefined,
+            });
+        }
+    }
+
+    return result;
+}
+
+function renderUrl(tpl: string, i: number) {
+    return tpl.replace(portTemplate, (_, base: string | undefined) => {
+        return String(base ? Number(base) + i : i);
+    });
+}
+
+function hasDynamicPort(url: string) {
+    try {
+        const _url = new URL(url);
+        return _url.protocol !== "xds:" && _url.port === "0";
+    } catch {
+        return false;
+    }
+}
+
 @applyMagic
 class ChainingProxy {
     [x: string]: any;
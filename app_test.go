@@ -2,6 +2,7 @@ package ngrpc_test
 
 import (
 	"context"
+	"errors"
 	"os/exec"
 	"testing"
 	"time"
@@ -105,6 +106,141 @@ func TestStartInvalidUrl(t *testing.T) {
 	assert.Equal(t, "parse \"grpc://localhost:abc\": invalid port \":abc\" after host", err.Error())
 }
 
+func TestStartWithDependencies(t *testing.T) {
+	cfg := config.Config{
+		Apps: []config.App{
+			{
+				Name:         "web-server",
+				Url:          "http://localhost:4010",
+				Dependencies: []string{"example-server"},
+			},
+			{
+				Name: "example-server",
+				Url:  "grpc://localhost:4000",
+				Services: []string{
+					"services.ExampleService",
+				},
+			},
+			{
+				Name: "unknown-server",
+				Url:  "grpc://localhost:4011",
+				Services: []string{
+					"services.UnknownService",
+				},
+			},
+		},
+	}
+
+	app := goext.Ok(ngrpc.StartWithConfig("web-server", cfg))
+	defer app.Stop()
+
+	ins, err := ngrpc.GetServiceClient(&services.ExampleService{}, "")
+	assert.NotNil(t, ins)
+	assert.Nil(t, err)
+}
+
+func TestStartWithoutDependencies(t *testing.T) {
+	cfg := config.Config{
+		Apps: []config.App{
+			{
+				Name: "web-server",
+				Url:  "http://localhost:4010",
+			},
+			{
+				Name: "unknown-server",
+				Url:  "grpc://localhost:4011",
+				Services: []string{
+					"services.UnknownService",
+				},
+			},
+		},
+	}
+
+	app, err := ngrpc.StartWithConfig("web-server", cfg)
+
+	assert.Nil(t, app)
+	assert.Equal(t, "service [services.UnknownService] hasn't been registered", err.Error())
+}
+
+func TestStartWithDynamicPort(t *testing.T) {
+	cfg := config.Config{
+		Apps: []config.App{
+			{
+				Name:  "example-server",
+				Url:   "grpc://localhost:0",
+				Serve: true,
+				Services: []string{
+					"services.ExampleService",
+				},
+			},
+		},
+	}
+
+	app := goext.Ok(ngrpc.StartWithConfig("example-server", cfg))
+	defer app.Stop()
+
+	assert.NotEqual(t, "grpc://localhost:0", app.Url)
+
+	srv := goext.Ok((&services.ExampleService{}).GetClient(""))
+	reply := goext.Ok(srv.SayHello(context.Background(), &proto.HelloRequest{Name: "World"}))
+	assert.Equal(t, "Hello, World", reply.Message)
+}
+
+type warmupService struct {
+	services.ExampleService
+	err    error
+	called bool
+}
+
+func (self *warmupService) Init(ctx context.Context) error {
+	self.called = true
+	return self.err
+}
+
+func TestStartWithInitHook(t *testing.T) {
+	service := &warmupService{}
+	ngrpc.Use(service)
+
+	cfg := config.Config{
+		Apps: []config.App{
+			{
+				Name:     "warmup-server",
+				Url:      "grpc://localhost:0",
+				Serve:    true,
+				Services: []string{"ngrpc_test.warmupService"},
+			},
+		},
+	}
+
+	app := goext.Ok(ngrpc.StartWithConfig("warmup-server", cfg))
+	defer app.Stop()
+
+	assert.True(t, service.called)
+}
+
+func TestStartWithInitHookFailure(t *testing.T) {
+	service := &warmupService{err: errors.New("database is not available")}
+	ngrpc.Use(service)
+
+	cfg := config.Config{
+		Apps: []config.App{
+			{
+				Name:     "warmup-server",
+				Url:      "grpc://localhost:0",
+				Serve:    true,
+				Services: []string{"ngrpc_test.warmupService"},
+			},
+		},
+	}
+
+	app, err := ngrpc.StartWithConfig("warmup-server", cfg)
+
+	assert.Nil(t, app)
+	assert.Equal(t,
+		"unable to initiate service [ngrpc_test.warmupService]: database is not available",
+		err.Error())
+}
+
 func TestStartDuplicateCall(t *testing.T) {
 	app1 := goext.Ok(ngrpc.Start("user-server"))
 	app2, err := ngrpc.Start("user-server")
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ayonli/ngrpc/pm"
+	"github.com/spf13/cobra"
+)
+
+var drainCmd = &cobra.Command{
+	Use:   "drain <app>",
+	Short: "remove an app from the rotation and wait for its in-flight calls to finish",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		stop, _ := cmd.Flags().GetBool("stop")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		err := pm.SendCommand("drain", args[0], pm.CommandOptions{Stop: stop, Timeout: timeout})
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var undrainCmd = &cobra.Command{
+	Use:   "undrain <app>",
+	Short: "put a drained app back into the rotation",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := pm.SendCommand("undrain", args[0])
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(drainCmd)
+	rootCmd.AddCommand(undrainCmd)
+	drainCmd.Flags().Bool("stop", false, "stop the app after it's drained")
+	drainCmd.Flags().Duration("timeout", 0,
+		"how long to wait for the in-flight calls to finish (default 30s)")
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ayonli/ngrpc/pm"
+	"github.com/spf13/cobra"
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec <entry> [args...]",
+	Short: "build the Go entry if it has changed and run it in place of this process",
+	Args:  cobra.MinimumNArgs(1),
+	// The args are passed to the program as is.
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := pm.ExecGoEntry(args[0], args[1:])
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+}
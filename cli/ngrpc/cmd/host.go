@@ -7,7 +7,6 @@ import (
 	"os/exec"
 	"time"
 
-	"github.com/ayonli/goext"
 	"github.com/ayonli/ngrpc/pm"
 	"github.com/spf13/cobra"
 )
@@ -28,7 +27,8 @@ var hostCmd = &cobra.Command{
 		} else if pm.IsHostOnline() {
 			fmt.Println("host server is already running")
 		} else {
-			err := startHost(true)
+			resurrect, _ := cmd.Flags().GetBool("resurrect")
+			err := startHost(true, resurrect)
 
 			if err != nil {
 				fmt.Println(err)
@@ -40,17 +40,24 @@ var hostCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(hostCmd)
 	hostCmd.Flags().Bool("stop", false, "stop the host server")
+	hostCmd.Flags().Bool("resurrect", false,
+		"resurrect the apps saved by `ngrpc save` once the host server starts")
 }
 
-func startHost(standalone bool) error {
+func startHost(standalone bool, resurrect bool) error {
 	cmd := exec.Command(os.Args[0], "host-server")
 
 	if standalone {
 		cmd.Args = append(cmd.Args, "--standalone")
 	}
 
-	cmd.Stdout = goext.Ok(os.OpenFile("host.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644))
-	cmd.Stderr = goext.Ok(os.OpenFile("host.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644))
+	if resurrect {
+		cmd.Args = append(cmd.Args, "--resurrect")
+	}
+
+	// The host server writes host.log via the log writer, which rotates the file, so its output is
+	// not redirected to the file here, otherwise the writes would keep going to the rotated one, the
+	// host server redirects its stderr to the current file by itself.
 	err := cmd.Start()
 
 	if err != nil {
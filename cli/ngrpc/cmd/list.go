@@ -1,16 +1,30 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/ayonli/ngrpc/pm"
 	"github.com/spf13/cobra"
 )
 
 var listCmd = &cobra.Command{
-	Use:     "list",
+	Use:     "list [app]",
 	Aliases: []string{"ls"},
-	Short:   "list all apps (exclude non-served ones)",
+	Short:   "list all apps or the replicas of an app (exclude non-served ones)",
 	Run: func(cmd *cobra.Command, args []string) {
-		pm.SendCommand("list", "")
+		var err error
+
+		if len(args) > 0 {
+			err = pm.SendCommand("list", args[0])
+		} else {
+			err = pm.SendCommand("list", "")
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	},
 }
 
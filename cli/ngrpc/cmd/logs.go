@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ayonli/ngrpc/pm"
+	"github.com/spf13/cobra"
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [app]",
+	Short: "print the logs of an app or all apps",
+	Run: func(cmd *cobra.Command, args []string) {
+		follow, _ := cmd.Flags().GetBool("follow")
+		lines, _ := cmd.Flags().GetInt("lines")
+		sinceStr, _ := cmd.Flags().GetString("since")
+		options := pm.LogsOptions{Lines: lines, Follow: follow}
+
+		if sinceStr != "" {
+			since, err := parseSince(sinceStr)
+
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			options.Since = since
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		appName := ""
+
+		if len(args) > 0 {
+			appName = args[0]
+		}
+
+		if err := pm.PrintLogs(ctx, os.Stdout, appName, options); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// parseSince parses either a duration relative to now, e.g. `10m`, or an absolute time.
+func parseSince(str string) (time.Time, error) {
+	if duration, err := time.ParseDuration(str); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+
+	layouts := []string{time.RFC3339, time.DateTime, "2006-01-02T15:04:05", time.DateOnly}
+
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time: %s", str)
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().BoolP("follow", "f", false, "keep printing the new lines of the logs")
+	logsCmd.Flags().IntP("lines", "n", 20, "the number of lines to print from the end, 0 means all")
+	logsCmd.Flags().String("since", "", "only print the lines after a time or a duration, e.g. 10m")
+}
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/ayonli/ngrpc/pm"
 	"github.com/spf13/cobra"
 )
@@ -9,10 +12,17 @@ var reloadCmd = &cobra.Command{
 	Use:   "reload [app]",
 	Short: "hot-reload an app or all apps",
 	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+
 		if len(args) > 0 {
-			pm.SendCommand("reload", args[0])
+			err = pm.SendCommand("reload", args[0])
 		} else {
-			pm.SendCommand("reload", "")
+			err = pm.SendCommand("reload", "")
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 	},
 }
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/ayonli/ngrpc/pm"
 	"github.com/spf13/cobra"
 )
@@ -9,14 +12,28 @@ var restartCmd = &cobra.Command{
 	Use:   "restart [app]",
 	Short: "restart an app or all apps",
 	Run: func(cmd *cobra.Command, args []string) {
+		rebuild, _ := cmd.Flags().GetBool("rebuild")
+		rolling, _ := cmd.Flags().GetBool("rolling")
+		options := pm.CommandOptions{Rebuild: rebuild, Rolling: rolling}
+
+		var err error
+
 		if len(args) > 0 {
-			pm.SendCommand("restart", args[0])
+			err = pm.SendCommand("restart", args[0], options)
 		} else {
-			pm.SendCommand("restart", "")
+			err = pm.SendCommand("restart", "", options)
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(restartCmd)
+	restartCmd.Flags().Bool("rebuild", false, "rebuild the Go entries even if they haven't changed")
+	restartCmd.Flags().Bool("rolling", false,
+		"restart the apps one by one, each after the previous one is online and healthy")
 }
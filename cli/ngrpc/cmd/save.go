@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ayonli/ngrpc/pm"
+	"github.com/spf13/cobra"
+)
+
+var saveCmd = &cobra.Command{
+	Use:   "save",
+	Short: "save the running apps so they can be resurrected later",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !pm.IsHostOnline() {
+			fmt.Println("host server is not running")
+			os.Exit(1)
+		}
+
+		err := pm.SendCommand("save", "")
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var resurrectCmd = &cobra.Command{
+	Use:   "resurrect",
+	Short: "start the host server and the apps saved by `ngrpc save`",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !pm.IsHostOnline() {
+			err := startHost(false, false)
+
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		rebuild, _ := cmd.Flags().GetBool("rebuild")
+		err := pm.SendCommand("resurrect", "", pm.CommandOptions{Rebuild: rebuild})
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(saveCmd)
+	rootCmd.AddCommand(resurrectCmd)
+	resurrectCmd.Flags().Bool("rebuild", false,
+		"rebuild the Go entries even if they haven't changed")
+}
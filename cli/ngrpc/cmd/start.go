@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/ayonli/ngrpc/pm"
 	"github.com/spf13/cobra"
@@ -12,22 +13,33 @@ var startCmd = &cobra.Command{
 	Short: "start an app or all apps (exclude non-served ones)",
 	Run: func(cmd *cobra.Command, args []string) {
 		if !pm.IsHostOnline() {
-			err := startHost(false)
+			err := startHost(false, false)
 
 			if err != nil {
 				fmt.Println(err)
-				return
+				os.Exit(1)
 			}
 		}
 
+		rebuild, _ := cmd.Flags().GetBool("rebuild")
+		options := pm.CommandOptions{Rebuild: rebuild}
+
+		var err error
+
 		if len(args) > 0 {
-			pm.SendCommand("start", args[0])
+			err = pm.SendCommand("start", args[0], options)
 		} else {
-			pm.SendCommand("start", "")
+			err = pm.SendCommand("start", "", options)
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(startCmd)
+	startCmd.Flags().Bool("rebuild", false, "rebuild the Go entries even if they haven't changed")
 }
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/pm"
+	"github.com/spf13/cobra"
+)
+
+var startupCmd = &cobra.Command{
+	Use:   "startup",
+	Short: "generate the startup scripts to run the host server (and apps) on boot",
+}
+
+var systemdCmd = &cobra.Command{
+	Use:   "systemd",
+	Short: "generate the systemd units of the host server (and apps)",
+	Run: func(cmd *cobra.Command, args []string) {
+		isUser, _ := cmd.Flags().GetBool("user")
+		withApps, _ := cmd.Flags().GetBool("apps")
+		outDir, _ := cmd.Flags().GetString("out")
+
+		if err := generateSystemdUnits(isUser, withApps, outDir); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(startupCmd)
+	startupCmd.AddCommand(systemdCmd)
+	systemdCmd.Flags().Bool("user", false, "generate user units instead of system units")
+	systemdCmd.Flags().Bool("apps", false, "generate a unit for each app as well")
+	systemdCmd.Flags().StringP("out", "o", "systemd", "the directory to write the unit files")
+}
+
+func generateSystemdUnits(isUser bool, withApps bool, outDir string) error {
+	conf, err := config.LoadConfig()
+
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		return err
+	} else if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	workDir, err := os.Getwd()
+
+	if err != nil {
+		return err
+	}
+
+	options := pm.SystemdOptions{
+		User:       isUser,
+		Apps:       withApps,
+		Executable: executable,
+		WorkDir:    workDir,
+	}
+
+	if current, err := user.Current(); err == nil {
+		options.RunAs = current.Username
+	}
+
+	units, err := pm.GenerateSystemdUnits(conf, options)
+
+	if err != nil {
+		return err
+	} else if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+
+	for _, unit := range units {
+		filename := filepath.Join(outDir, unit.Name)
+
+		if err := os.WriteFile(filename, []byte(unit.Content), 0644); err != nil {
+			return err
+		}
+
+		fmt.Printf("unit file written: %s\n", filename)
+	}
+
+	fmt.Println()
+	fmt.Println("To install the units, run:")
+	fmt.Println()
+
+	for _, step := range pm.GetSystemdInstallSteps(units, options, outDir) {
+		fmt.Println("    " + step)
+	}
+
+	if !withApps {
+		fmt.Println()
+		fmt.Println("NOTE: the host server resurrects the apps saved by `ngrpc save` on boot.")
+	}
+
+	return nil
+}
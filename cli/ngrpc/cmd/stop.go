@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/ayonli/ngrpc/pm"
 	"github.com/spf13/cobra"
 )
@@ -9,14 +12,29 @@ var stopCmd = &cobra.Command{
 	Use:   "stop [app]",
 	Short: "stop an app or all apps",
 	Run: func(cmd *cobra.Command, args []string) {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		force, _ := cmd.Flags().GetBool("force")
+		options := pm.CommandOptions{Timeout: timeout, Force: force}
+
+		var err error
+
 		if len(args) > 0 {
-			pm.SendCommand("stop", args[0])
+			err = pm.SendCommand("stop", args[0], options)
 		} else {
-			pm.SendCommand("stop", "")
+			err = pm.SendCommand("stop", "", options)
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(stopCmd)
+	stopCmd.Flags().Duration("timeout", 0,
+		"how long to wait for the processes to exit (default 10s with --force)")
+	stopCmd.Flags().Bool("force", false,
+		"send SIGTERM, then SIGKILL to the processes that don't exit in time")
 }
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"fmt"
+	"log"
 	"os"
+	"slices"
 
 	"github.com/ayonli/ngrpc/cli/ngrpc/cmd"
 	"github.com/ayonli/ngrpc/config"
@@ -16,18 +17,41 @@ func main() {
 	if len(args) > 1 && args[1] == "host-server" {
 		config, err := config.LoadConfig()
 
+		// Write the host server's log via the log writer, so the file can be rotated as well, the
+		// errors are logged instead of printed, since the output of the host server is discarded.
+		// The stderr is redirected to the file too, so the panics are not lost.
+		if writer, err := pm.NewLogWriter("host", "host.log", config.Log); err == nil {
+			log.SetOutput(writer)
+			defer writer.Close()
+
+			if err := writer.RedirectStderr(); err != nil {
+				log.Printf("unable to redirect the stderr: %v", err)
+			}
+		}
+
 		if err != nil {
-			fmt.Println(err)
+			log.Println(err)
 			return
 		}
 
-		standalone := len(args) > 2 && args[2] == "--standalone"
+		standalone := slices.Contains(args[2:], "--standalone")
 		host := pm.NewHost(config, standalone)
-		err = host.Start(true)
+		err = host.Start(false)
 
 		if err != nil {
-			fmt.Println(err)
+			log.Println(err)
+			return
 		}
+
+		if slices.Contains(args[2:], "--resurrect") {
+			go func() {
+				if err := host.Resurrect(); err != nil {
+					log.Printf("unable to resurrect the apps: %v", err)
+				}
+			}()
+		}
+
+		host.WaitForExit()
 	} else {
 		cmd.Execute()
 	}
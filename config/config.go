@@ -7,14 +7,61 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/ayonli/goext"
+	"github.com/ayonli/goext/slicex"
 	"github.com/ayonli/ngrpc/util"
 	"github.com/tidwall/jsonc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// LogOptions is used to configure how the host server writes the log files.
+type LogOptions struct {
+	// The maximum size in megabytes of the log file before it gets rotated, `0` means no limit.
+	MaxSize int `json:"maxSize"`
+	// The maximum age in hours of the log file before it gets rotated, `0` means no limit.
+	MaxAge int `json:"maxAge"`
+	// The number of rotated files to keep, the older ones will be removed. The default value is
+	// `10`, a negative value means unlimited.
+	MaxFiles int `json:"maxFiles"`
+	// Compress the rotated files with gzip.
+	Compress bool `json:"compress"`
+	// Prepend a timestamp to each line of the log.
+	Timestamp bool `json:"timestamp"`
+	// Prepend the app name, e.g. `[user-server]`, to each line of the log.
+	Prefix bool `json:"prefix"`
+}
+
+// HealthCheckOptions is used to configure how the host server probes the apps.
+type HealthCheckOptions struct {
+	// The interval in milliseconds between the probes, the default value is `10_000` ms, a
+	// negative value disables the health check.
+	Interval int `json:"interval"`
+	// The time in milliseconds to wait for each probe, the default value is `5_000` ms.
+	Timeout int `json:"timeout"`
+	// The number of consecutive failures before the app is marked as unhealthy and restarted, the
+	// default value is `3`.
+	Retries int `json:"retries"`
+}
+
+// HeartbeatOptions is used to configure the heartbeats between the host server and the apps.
+type HeartbeatOptions struct {
+	// The interval in milliseconds between the heartbeats, the default value is `5_000` ms, a
+	// negative value disables the heartbeats.
+	Interval int `json:"interval"`
+	// The number of heartbeats missed in a row before the peer is considered unresponsive, the
+	// default value is `3`.
+	MaxMisses int `json:"maxMisses"`
+	// Kill and respawn the app once it's unresponsive, otherwise, the app is only marked as
+	// unresponsive.
+	Restart bool `json:"restart"`
+}
+
 // App is used both to configure the apps.
 type App struct {
 	// The name of the app.
@@ -41,6 +88,46 @@ type App struct {
 	Stderr string            `json:"stderr"`
 	Entry  string            `json:"entry"`
 	Env    map[string]string `json:"env"`
+	// The number of replicas to spawn for this app, each replica is named `<name>#<i>` (`i` starts
+	// from `0`), and its URL may use a port template like `grpc://localhost:{4000+i}`.
+	Instances int `json:"instances"`
+	// The restart policy when the app exits unexpectedly, possible values are `always` (default),
+	// `on-failure` (only restart when the app exits with failure) and `never`.
+	Restart string `json:"restart"`
+	// The maximum number of restarts within the `restartWindow`, once reached, the app is marked as
+	// `errored` and will not be restarted anymore. The default value is `10`, a negative value
+	// means unlimited.
+	MaxRestarts int `json:"maxRestarts"`
+	// The time window in milliseconds for counting `maxRestarts`, the default value is `60_000` ms.
+	RestartWindow int `json:"restartWindow"`
+	// The initial delay in milliseconds before restarting the app, it doubles on each consecutive
+	// restart within the `restartWindow`, the default value is `1_000` ms.
+	RestartDelay int `json:"restartDelay"`
+	// The time in milliseconds to wait for the app to come online when starting it via the CLI,
+	// after that, the app is reported as failed. The default value is `30_000` ms.
+	StartTimeout int `json:"startTimeout"`
+	// The names of the apps that this app connects to. When set, only the services of these apps
+	// (and the app itself) will be dialed and required to be registered, other apps are skipped.
+	// When omitted, the app connects to all apps in the config.
+	Dependencies []string `json:"dependencies"`
+	// The names of the apps that must be online before this app starts, the CLI starts the apps in
+	// the order of their dependencies, and stops them in the reverse order.
+	DependsOn []string `json:"dependsOn"`
+	// The options of the `stdout` and `stderr` log files, when omitted, the top-level `log` option
+	// is used.
+	Log *LogOptions `json:"log"`
+	// The memory limit in megabytes, once the app uses more memory than this, the host server
+	// restarts it gracefully. `0` means no limit.
+	MaxMemory int `json:"maxMemory"`
+	// A cron expression, e.g. `0 3 * * *`, the host server restarts the app gracefully on this
+	// schedule.
+	RestartCron string `json:"restartCron"`
+	// The options of the health check, the host server probes each served app with a ping via the
+	// control channel and a gRPC health check, and restarts the app once it's unhealthy.
+	HealthCheck *HealthCheckOptions `json:"healthCheck"`
+	// The options of the heartbeats, the host server and the app ping each other periodically, so
+	// a hung app or a dead host server is detected without waiting for the connection to be closed.
+	Heartbeat *HeartbeatOptions `json:"heartbeat"`
 }
 
 // Config is used to store configurations of the apps.
@@ -51,6 +138,8 @@ type Config struct {
 	ImportRoot string   `json:"importRoot"`
 	ProtoPaths []string `json:"protoPaths"`
 	Apps       []App    `json:"apps"`
+	// The default log options of the apps, also used for the `host.log` file.
+	Log *LogOptions `json:"log"`
 }
 
 func LoadConfig() (Config, error) {
@@ -86,9 +175,27 @@ func LoadConfig() (Config, error) {
 				app.Entry = cfg.Entry
 			}
 
+			if app.Log == nil && cfg.Log != nil {
+				app.Log = cfg.Log
+			}
+
 			apps = append(apps, app)
 		}
 
+		apps, err := ExpandApps(apps)
+
+		if err != nil {
+			return Config{}, err
+		}
+
+		if err := checkDependsOn(apps); err != nil {
+			return Config{}, err
+		}
+
+		if err := checkRestartCron(apps); err != nil {
+			return Config{}, err
+		}
+
 		cfg.Apps = apps
 
 		return *cfg, nil
@@ -97,6 +204,185 @@ func LoadConfig() (Config, error) {
 	}
 }
 
+var portTemplate = regexp.MustCompile(`\{\s*(?:(\d+)\s*\+\s*)?i\s*\}`)
+
+// ExpandApps expands the apps that have multiple `instances` into replicas, each replica is named
+// `<name>#<i>` and has its URL port evaluated from the template, e.g. `grpc://localhost:{4000+i}`.
+//
+// This function is idempotent, the replicas will not be expanded again.
+func ExpandApps(apps []App) ([]App, error) {
+	result := []App{}
+
+	for _, app := range apps {
+		if app.Instances <= 1 {
+			app.Instances = 0
+			app.Url = renderUrl(app.Url, 0)
+			result = append(result, app)
+			continue
+		} else if !portTemplate.MatchString(app.Url) && !HasDynamicPort(app.Url) {
+			return nil, fmt.Errorf(
+				"app [%s] has multiple instances but its URL is neither a template nor using port 0",
+				app.Name)
+		}
+
+		for i := 0; i < app.Instances; i++ {
+			replica := app
+			replica.Name = fmt.Sprintf("%s#%d", app.Name, i)
+			replica.Url = renderUrl(app.Url, i)
+			replica.Instances = 0
+			result = append(result, replica)
+		}
+	}
+
+	return result, nil
+}
+
+func renderUrl(tpl string, i int) string {
+	return portTemplate.ReplaceAllStringFunc(tpl, func(match string) string {
+		base := portTemplate.FindStringSubmatch(match)[1]
+
+		if base == "" {
+			return strconv.Itoa(i)
+		}
+
+		num, _ := strconv.Atoi(base)
+		return strconv.Itoa(num + i)
+	})
+}
+
+// HasDynamicPort checks if the URL uses port `0`, in which case the port is allocated by the system
+// when the server starts, and the clients shall resolve the actual URL from the host server.
+func HasDynamicPort(rawUrl string) bool {
+	urlObj, err := url.Parse(rawUrl)
+	return err == nil && urlObj.Scheme != "xds" && urlObj.Port() == "0"
+}
+
+// GetBaseName returns the name of the app without the replica suffix `#<i>`.
+func GetBaseName(name string) string {
+	if idx := strings.LastIndex(name, "#"); idx > 0 {
+		return name[:idx]
+	}
+
+	return name
+}
+
+// MatchApp checks if the `name` is the `target` app itself or one of its replicas.
+func MatchApp(name string, target string) bool {
+	return name == target || GetBaseName(name) == target
+}
+
+// GroupApps groups the apps by their `dependsOn`, each group only depends on the apps in the
+// previous groups, so the groups can be started one after another, and the apps in the same group
+// can be started at the same time. The dependencies that are not in the list are ignored.
+//
+// An error is returned if there is a circular dependency between the apps.
+func GroupApps(apps []App) ([][]App, error) {
+	deps := make([][]int, len(apps))
+
+	for i, app := range apps {
+		for _, dep := range app.DependsOn {
+			for j, other := range apps {
+				if MatchApp(other.Name, dep) {
+					deps[i] = append(deps[i], j)
+				}
+			}
+		}
+	}
+
+	groups := [][]App{}
+	levels := make([]int, len(apps)) // 0 means the level is not resolved yet
+	resolved := 0
+
+	for resolved < len(apps) {
+		group := []int{}
+
+		for i := range apps {
+			if levels[i] != 0 {
+				continue
+			}
+
+			ready := slicex.Every(deps[i], func(j int, _ int) bool {
+				return levels[j] != 0
+			})
+
+			if ready {
+				group = append(group, i)
+			}
+		}
+
+		if len(group) == 0 {
+			cycle := findCycle(apps, deps, levels)
+			return nil, fmt.Errorf("circular dependency detected: %s", cycle)
+		}
+
+		for _, i := range group {
+			levels[i] = len(groups) + 1
+		}
+
+		resolved += len(group)
+		groups = append(groups, slicex.Map(group, func(i int, _ int) App {
+			return apps[i]
+		}))
+	}
+
+	return groups, nil
+}
+
+// findCycle returns a readable path of the circular dependency among the unresolved apps, e.g.
+// `a -> b -> a`.
+func findCycle(apps []App, deps [][]int, levels []int) string {
+	path := []int{}
+	visited := make([]bool, len(apps))
+
+	// Every unresolved app depends on at least one unresolved app, so following the unresolved
+	// dependencies will eventually run into a cycle.
+	for current := slices.Index(levels, 0); !visited[current]; {
+		visited[current] = true
+		path = append(path, current)
+		current, _ = slicex.Find(deps[current], func(j int, _ int) bool {
+			return levels[j] == 0
+		})
+
+		if visited[current] {
+			path = append(path[slices.Index(path, current):], current)
+		}
+	}
+
+	return strings.Join(slicex.Map(path, func(i int, _ int) string {
+		return GetBaseName(apps[i].Name)
+	}), " -> ")
+}
+
+func checkDependsOn(apps []App) error {
+	for _, app := range apps {
+		for _, dep := range app.DependsOn {
+			exists := slicex.Some(apps, func(other App, _ int) bool {
+				return MatchApp(other.Name, dep)
+			})
+
+			if !exists {
+				return fmt.Errorf("app [%s] depends on app [%s] which doesn't exist",
+					GetBaseName(app.Name), dep)
+			}
+		}
+	}
+
+	_, err := GroupApps(apps)
+	return err
+}
+
+func checkRestartCron(apps []App) error {
+	for _, app := range apps {
+		if app.RestartCron == "" {
+			continue
+		} else if _, err := util.ParseCron(app.RestartCron); err != nil {
+			return fmt.Errorf("app [%s] has an invalid restartCron: %w", GetBaseName(app.Name), err)
+		}
+	}
+
+	return nil
+}
+
 func GetAddress(urlObj *url.URL) string {
 	addr := urlObj.Hostname()
 
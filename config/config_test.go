@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/ayonli/goext"
+	"github.com/ayonli/goext/slicex"
 	"github.com/ayonli/ngrpc/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -36,6 +37,125 @@ func TestLoadConfigFailure(t *testing.T) {
 	assert.Equal(t, "unable to load config file: "+filename, err.Error())
 }
 
+func TestExpandApps(t *testing.T) {
+	apps := goext.Ok(ExpandApps([]App{
+		{
+			Name:      "user-server",
+			Url:       "grpc://localhost:{4000+i}",
+			Serve:     true,
+			Instances: 3,
+		},
+		{
+			Name: "post-server",
+			Url:  "grpc://localhost:4010",
+		},
+	}))
+
+	assert.Equal(t,
+		[]string{"user-server#0", "user-server#1", "user-server#2", "post-server"},
+		slicex.Map(apps, func(app App, _ int) string { return app.Name }))
+	assert.Equal(t,
+		[]string{
+			"grpc://localhost:4000",
+			"grpc://localhost:4001",
+			"grpc://localhost:4002",
+			"grpc://localhost:4010",
+		},
+		slicex.Map(apps, func(app App, _ int) string { return app.Url }))
+
+	// expanding again changes nothing
+	assert.Equal(t, apps, goext.Ok(ExpandApps(apps)))
+}
+
+func TestExpandAppsWithoutTemplate(t *testing.T) {
+	_, err := ExpandApps([]App{
+		{
+			Name:      "user-server",
+			Url:       "grpc://localhost:4000",
+			Instances: 2,
+		},
+	})
+
+	assert.Equal(t,
+		"app [user-server] has multiple instances but its URL is neither a template nor using port 0",
+		err.Error())
+}
+
+func TestHasDynamicPort(t *testing.T) {
+	assert.True(t, HasDynamicPort("grpc://localhost:0"))
+	assert.False(t, HasDynamicPort("grpc://localhost:4000"))
+	assert.False(t, HasDynamicPort("grpc://localhost"))
+	assert.False(t, HasDynamicPort("xds://localhost:0"))
+}
+
+func TestMatchApp(t *testing.T) {
+	assert.Equal(t, "user-server", GetBaseName("user-server#1"))
+	assert.Equal(t, "user-server", GetBaseName("user-server"))
+	assert.True(t, MatchApp("user-server#1", "user-server"))
+	assert.True(t, MatchApp("user-server#1", "user-server#1"))
+	assert.False(t, MatchApp("user-server#1", "user-server#2"))
+	assert.False(t, MatchApp("user-server", "user-server#1"))
+}
+
+func TestGroupApps(t *testing.T) {
+	apps := goext.Ok(ExpandApps([]App{
+		{Name: "user-server", DependsOn: []string{"post-server"}},
+		{Name: "post-server", Url: "grpc://localhost:{4000+i}", Instances: 2},
+		{Name: "example-server"},
+		{Name: "gateway", DependsOn: []string{"user-server", "example-server"}},
+	}))
+	groups := goext.Ok(GroupApps(apps))
+	names := slicex.Map(groups, func(group []App, _ int) []string {
+		return slicex.Map(group, func(app App, _ int) string { return app.Name })
+	})
+
+	assert.Equal(t, [][]string{
+		{"post-server#0", "post-server#1", "example-server"},
+		{"user-server"},
+		{"gateway"},
+	}, names)
+
+	// The dependencies that are not in the list are ignored.
+	groups = goext.Ok(GroupApps(apps[:1]))
+	assert.Equal(t, 1, len(groups))
+}
+
+func TestGroupAppsWithCycle(t *testing.T) {
+	_, err := GroupApps([]App{
+		{Name: "example-server"},
+		{Name: "user-server", DependsOn: []string{"post-server"}},
+		{Name: "post-server", DependsOn: []string{"gateway"}},
+		{Name: "gateway", DependsOn: []string{"user-server", "example-server"}},
+	})
+	assert.EqualError(t, err,
+		"circular dependency detected: user-server -> post-server -> gateway -> user-server")
+
+	_, err = GroupApps([]App{{Name: "user-server", DependsOn: []string{"user-server"}}})
+	assert.EqualError(t, err, "circular dependency detected: user-server -> user-server")
+}
+
+func TestLoadConfigWithInvalidDependsOn(t *testing.T) {
+	conf := `{"apps":[{"name":"user-server","url":"grpc://localhost:4001",` +
+		`"dependsOn":["unknown-server"]}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	defer os.Remove("ngrpc.json")
+
+	_, err := LoadConfig()
+	assert.EqualError(t, err,
+		"app [user-server] depends on app [unknown-server] which doesn't exist")
+}
+
+func TestLoadConfigWithInvalidRestartCron(t *testing.T) {
+	conf := `{"apps":[{"name":"user-server","url":"grpc://localhost:4001",` +
+		`"restartCron":"0 25 * * *"}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	defer os.Remove("ngrpc.json")
+
+	_, err := LoadConfig()
+	assert.EqualError(t, err, `app [user-server] has an invalid restartCron: `+
+		`invalid cron expression "0 25 * * *": value 25 out of range [0, 23]`)
+}
+
 func TestGetAddress(t *testing.T) {
 	urlObj1, _ := url.Parse("grpc://localhost:6000")
 	urlObj2, _ := url.Parse("grpc://localhost")
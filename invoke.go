@@ -0,0 +1,221 @@
+package ngrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/ayonli/goext"
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Invoke calls a method of a service without the need of registering the service in Golang, which
+// is useful to call the services that are only implemented in Node.js.
+//
+// `method` is in the form of `<service>/<method>`, for example, `services.ExampleService/sayHello`.
+// The service is resolved from the `.proto` files in the `protoPaths` of the config file, and the
+// call is routed to the apps that serve it, just like `GetServiceClient()` does.
+//
+// `req` can be a `proto.Message`, a JSON string or bytes, or any value that can be marshaled to
+// JSON.
+func Invoke(ctx context.Context, method string, req any) (*dynamicpb.Message, error) {
+	return InvokeWithRoute(ctx, method, req, "")
+}
+
+// InvokeWithRoute is like `Invoke()` except it takes a `route` argument which is used to route
+// traffic by the client-side load balancer.
+func InvokeWithRoute(
+	ctx context.Context,
+	method string,
+	req any,
+	route string,
+) (*dynamicpb.Message, error) {
+	return goext.Try(func() *dynamicpb.Message {
+		if theApp == nil {
+			panic("no app is running")
+		}
+
+		serviceName, methodName, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+
+		if !ok || serviceName == "" || methodName == "" {
+			panic(fmt.Errorf("invalid method name: %s", method))
+		}
+
+		service := goext.Ok(theApp.findServiceDescriptor(serviceName))
+		methodDesc := findMethodDescriptor(service, methodName)
+
+		if methodDesc == nil {
+			panic(fmt.Errorf("method %s is not found in service %s", methodName, serviceName))
+		} else if methodDesc.IsStreamingClient() || methodDesc.IsStreamingServer() {
+			panic(fmt.Errorf("streaming method %s is not supported", methodName))
+		}
+
+		conn := goext.Ok(theApp.getDynamicConn(serviceName, route))
+		in := goext.Ok(newDynamicRequest(methodDesc.Input(), req))
+		out := dynamicpb.NewMessage(methodDesc.Output())
+		fullMethod := fmt.Sprintf("/%s/%s", serviceName, methodDesc.Name())
+
+		goext.Ok(0, conn.Invoke(ctx, fullMethod, in, out))
+
+		return out
+	})
+}
+
+func findMethodDescriptor(
+	service protoreflect.ServiceDescriptor,
+	methodName string,
+) protoreflect.MethodDescriptor {
+	methods := service.Methods()
+
+	if method := methods.ByName(protoreflect.Name(methodName)); method != nil {
+		return method
+	}
+
+	// Node.js programs usually use camel-case method names, e.g. `sayHello` for `SayHello`.
+	return methods.ByName(protoreflect.Name(strings.ToUpper(methodName[:1]) + methodName[1:]))
+}
+
+func newDynamicRequest(desc protoreflect.MessageDescriptor, req any) (proto.Message, error) {
+	var data []byte
+
+	switch value := req.(type) {
+	case proto.Message:
+		// The message is encoded by the gRPC codec, there is no need to convert it.
+		return value, nil
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		var err error
+
+		if data, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	msg := dynamicpb.NewMessage(desc)
+	err := protojson.Unmarshal(data, msg)
+
+	return msg, err
+}
+
+// getDynamicConn dials the apps that serve the service (on demand) and selects one of the
+// connections according to the `route`.
+func (self *RpcApp) getDynamicConn(serviceName string, route string) (*grpc.ClientConn, error) {
+	return goext.Try(func() *grpc.ClientConn {
+		lock, ok := self.locks.Get(serviceName)
+
+		if !ok {
+			panic(fmt.Errorf("service %s is not configured", serviceName))
+		} else {
+			lock.Lock()
+			defer lock.Unlock()
+		}
+
+		record, ok := self.dynamicServices.Get(serviceName)
+
+		if !ok {
+			dialers, _ := self.serviceDialers.Get(serviceName)
+			record = &remoteService{instances: []remoteInstance{}, counter: 0}
+
+			for _, entry := range dialers {
+				conn := goext.Ok(entry.dial())
+				record.instances = append(record.instances, remoteInstance{
+					app:      entry.app.Name,
+					url:      entry.app.Url,
+					conn:     conn,
+					instance: conn,
+				})
+			}
+
+			self.dynamicServices.Set(serviceName, record)
+		}
+
+		instance, ok := record.pick(route)
+
+		if !ok {
+			panic(fmt.Errorf("service %s is not available", serviceName))
+		}
+
+		return instance.conn
+	})
+}
+
+// findServiceDescriptor resolves the service from the `.proto` files in the `protoPaths`, the files
+// are loaded only once when the first time this function is called, so is the error of loading
+// them.
+func (self *RpcApp) findServiceDescriptor(
+	serviceName string,
+) (protoreflect.ServiceDescriptor, error) {
+	self.protoLock.Lock()
+	defer self.protoLock.Unlock()
+
+	if self.protoFiles == nil && self.protoErr == nil {
+		self.protoFiles, self.protoErr = loadProtoFiles(self.protoPaths)
+	}
+
+	if self.protoErr != nil {
+		return nil, self.protoErr
+	}
+
+	resolver := self.protoFiles.AsResolver()
+	desc, err := resolver.FindDescriptorByName(protoreflect.FullName(serviceName))
+
+	if err != nil {
+		return nil, fmt.Errorf("service %s is not found in the proto files", serviceName)
+	} else if service, ok := desc.(protoreflect.ServiceDescriptor); ok {
+		return service, nil
+	} else {
+		return nil, fmt.Errorf("%s is not a service", serviceName)
+	}
+}
+
+func loadProtoFiles(protoPaths []string) (linker.Files, error) {
+	if len(protoPaths) == 0 {
+		return nil, errors.New("'protoPaths' is not configured")
+	}
+
+	filenames := []string{}
+
+	for _, dir := range protoPaths {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			} else if entry.IsDir() || filepath.Ext(path) != ".proto" {
+				return nil
+			}
+
+			// The filenames must be relative to the import paths.
+			filename, err := filepath.Rel(dir, path)
+
+			if err == nil {
+				filenames = append(filenames, filepath.ToSlash(filename))
+			}
+
+			return err
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			ImportPaths: protoPaths,
+		}),
+	}
+
+	return compiler.Compile(context.Background(), filenames...)
+}
@@ -0,0 +1,73 @@
+package ngrpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/services/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoke(t *testing.T) {
+	cfg := config.Config{
+		ProtoPaths: []string{"proto"},
+		Apps: []config.App{
+			{
+				Name:  "example-server",
+				Url:   "grpc://localhost:4020",
+				Serve: true,
+				Services: []string{
+					"services.ExampleService",
+				},
+			},
+		},
+	}
+	app := goext.Ok(ngrpc.StartWithConfig("example-server", cfg))
+	defer app.Stop()
+
+	ctx := context.Background()
+	reply1 := goext.Ok(ngrpc.Invoke(ctx, "services.ExampleService/sayHello", `{"name":"World"}`))
+	reply2 := goext.Ok(ngrpc.Invoke(ctx, "/services.ExampleService/SayHello", map[string]string{
+		"name": "A-yon Lee",
+	}))
+	reply3 := goext.Ok(ngrpc.InvokeWithRoute(ctx, "services.ExampleService/SayHello",
+		&proto.HelloRequest{Name: "Golang"}, "example-server"))
+
+	field := reply1.Descriptor().Fields().ByName("message")
+	assert.Equal(t, "Hello, World", reply1.Get(field).String())
+	assert.Equal(t, "Hello, A-yon Lee", reply2.Get(field).String())
+	assert.Equal(t, "Hello, Golang", reply3.Get(field).String())
+}
+
+func TestInvokeInvalidMethod(t *testing.T) {
+	cfg := config.Config{
+		ProtoPaths: []string{"proto"},
+		Apps: []config.App{
+			{
+				Name: "example-server",
+				Url:  "grpc://localhost:4020",
+				Services: []string{
+					"services.ExampleService",
+				},
+			},
+		},
+	}
+	app := goext.Ok(ngrpc.StartWithConfig("", cfg))
+	defer app.Stop()
+
+	ctx := context.Background()
+	_, err1 := ngrpc.Invoke(ctx, "services.ExampleService", "{}")
+	_, err2 := ngrpc.Invoke(ctx, "services.ExampleService/sayGoodbye", "{}")
+	_, err3 := ngrpc.Invoke(ctx, "services.UnknownService/sayHello", "{}")
+
+	assert.Equal(t, "invalid method name: services.ExampleService", err1.Error())
+	assert.Equal(t,
+		"method sayGoodbye is not found in service services.ExampleService",
+		err2.Error())
+	assert.Equal(t,
+		"service services.UnknownService is not found in the proto files",
+		err3.Error())
+}
@@ -0,0 +1,222 @@
+package pm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/util"
+)
+
+var buildLock sync.Mutex
+
+// BuildGoEntry builds the Go entry file into a binary in the cache directory and returns the path
+// of the binary. The binary is keyed by the hash of the sources of the module, so the entry is only
+// rebuilt when the sources change, unless `rebuild` is set. Once built, the earlier builds of the
+// entry are removed.
+func BuildGoEntry(entry string, rebuild bool) (string, error) {
+	buildLock.Lock()
+	defer buildLock.Unlock()
+
+	return goext.Try(func() string {
+		hash := goext.Ok(hashGoSources(entry))
+		// The entries of the same name in different directories are told apart by their paths.
+		entryHash := sha256.Sum256([]byte(util.AbsPath(entry, false)))
+		prefix := strings.TrimSuffix(filepath.Base(entry), ".go") + "-" +
+			hex.EncodeToString(entryHash[:])[:8] + "-"
+		name := prefix + hash[:16]
+
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+
+		dir := getBuildCacheDir()
+		binary := filepath.Join(dir, name)
+
+		if !rebuild && util.Exists(binary) {
+			return binary
+		}
+
+		goext.Ok(0, util.EnsureDir(dir))
+
+		// Build into a temporary file and rename it afterwards, so another process (the CLI or the
+		// host server) will never see a partially written binary.
+		tmpFile := fmt.Sprintf("%s.%d.tmp", binary, os.Getpid())
+
+		// The output is kept in the error, since the host server's output is not visible.
+		output, err := exec.Command("go", "build", "-o", tmpFile, entry).CombinedOutput()
+
+		if err != nil {
+			os.Remove(tmpFile)
+			panic(fmt.Errorf("unable to build %s: %w\n%s", entry, err,
+				strings.TrimRight(string(output), "\n")))
+		}
+
+		goext.Ok(0, os.Rename(tmpFile, binary))
+		pruneGoBuilds(dir, prefix, binary)
+
+		return binary
+	})
+}
+
+// ExecGoEntry builds the Go entry like `BuildGoEntry()` does, then replaces the current process with
+// the binary, so the app runs as this process, e.g. the main process of a systemd unit. It only
+// returns on failure.
+func ExecGoEntry(entry string, args []string) error {
+	binary, err := BuildGoEntry(entry, false)
+
+	if err != nil {
+		return err
+	}
+
+	return execProcess(binary, args)
+}
+
+// pruneGoBuilds removes the earlier builds of the entry, the ones used by the running apps may fail
+// to be removed on Windows, they'll be removed after the next build.
+func pruneGoBuilds(dir string, prefix string, binary string) {
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return
+	}
+
+	for _, item := range entries {
+		filename := filepath.Join(dir, item.Name())
+
+		if !item.IsDir() && strings.HasPrefix(item.Name(), prefix) && filename != binary &&
+			!strings.HasSuffix(item.Name(), ".tmp") {
+			os.Remove(filename)
+		}
+	}
+}
+
+func getBuildCacheDir() string {
+	dir, err := os.UserCacheDir()
+
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "ngrpc", "bin")
+}
+
+// hashGoSources computes the hash of the Go sources (and the go.mod and go.sum files) of the module
+// that the entry file belongs to, and of the local modules that it replaces the dependencies with.
+func hashGoSources(entry string) (string, error) {
+	entry = util.AbsPath(entry, false)
+	root := findModuleRoot(filepath.Dir(entry))
+	hash := sha256.New()
+	hash.Write([]byte(entry + "\n" + runtime.GOOS + "/" + runtime.GOARCH + "\n"))
+
+	for _, dir := range append([]string{root}, findLocalReplaces(root)...) {
+		err := filepath.WalkDir(dir, func(path string, item fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			name := item.Name()
+
+			if item.IsDir() {
+				if path != dir && (strings.HasPrefix(name, ".") || name == "node_modules") {
+					return filepath.SkipDir
+				}
+
+				return nil
+			} else if filepath.Ext(name) != ".go" && name != "go.mod" && name != "go.sum" {
+				return nil
+			}
+
+			file, err := os.Open(path)
+
+			if err != nil {
+				return err
+			}
+
+			defer file.Close()
+			hash.Write([]byte(path + "\n"))
+			_, err = io.Copy(hash, file)
+
+			return err
+		})
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findLocalReplaces returns the directories of the local modules in the `replace` directives of the
+// go.mod file in the module root, e.g. `replace example.com/lib => ../lib`.
+func findLocalReplaces(root string) []string {
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+
+	if err != nil {
+		return nil
+	}
+
+	dirs := []string{}
+	inBlock := false
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		line = strings.TrimSpace(line)
+
+		if line == "replace (" {
+			inBlock = true
+			continue
+		} else if inBlock && line == ")" {
+			inBlock = false
+			continue
+		} else if strings.HasPrefix(line, "replace ") {
+			line = strings.TrimPrefix(line, "replace ")
+		} else if !inBlock {
+			continue
+		}
+
+		_, target, ok := strings.Cut(line, "=>")
+		fields := strings.Fields(target)
+
+		// The target is a local path if it has no version.
+		if !ok || len(fields) != 1 {
+			continue
+		}
+
+		dir := filepath.FromSlash(strings.Trim(fields[0], "\"`"))
+
+		if filepath.IsAbs(dir) {
+			dirs = append(dirs, dir)
+		} else if strings.HasPrefix(fields[0], "./") || strings.HasPrefix(fields[0], "../") {
+			dirs = append(dirs, filepath.Join(root, dir))
+		}
+	}
+
+	return dirs
+}
+
+func findModuleRoot(dir string) string {
+	for current := dir; ; {
+		if util.Exists(filepath.Join(current, "go.mod")) {
+			return current
+		}
+
+		parent := filepath.Dir(current)
+
+		if parent == current {
+			return dir
+		}
+
+		current = parent
+	}
+}
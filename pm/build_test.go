@@ -0,0 +1,120 @@
+package pm
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildGoEntry(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("testdata/hello", 0755))
+	defer os.RemoveAll("testdata")
+
+	writeEntry := func(text string) {
+		code := "package main\n\nfunc main() { println(\"" + text + "\") }\n"
+		goext.Ok(0, os.WriteFile("testdata/hello/main.go", []byte(code), 0644))
+	}
+
+	writeEntry("hello")
+	binary := goext.Ok(BuildGoEntry("testdata/hello/main.go", false))
+	defer os.Remove(binary)
+
+	assert.True(t, strings.HasPrefix(filepath.Base(binary), "main-"))
+	assert.Equal(t, getBuildCacheDir(), filepath.Dir(binary))
+
+	out := goext.Ok(exec.Command(binary).CombinedOutput())
+	assert.Equal(t, "hello\n", string(out))
+
+	// The binary is reused as long as the sources haven't changed.
+	stat := goext.Ok(os.Stat(binary))
+	binary2 := goext.Ok(BuildGoEntry("testdata/hello/main.go", false))
+	stat2 := goext.Ok(os.Stat(binary2))
+	assert.Equal(t, binary, binary2)
+	assert.Equal(t, stat.ModTime(), stat2.ModTime())
+
+	// Force rebuild.
+	binary3 := goext.Ok(BuildGoEntry("testdata/hello/main.go", true))
+	stat3 := goext.Ok(os.Stat(binary3))
+	assert.Equal(t, binary, binary3)
+	assert.NotEqual(t, stat.ModTime(), stat3.ModTime())
+
+	// A change of the sources produces a new binary, and the earlier one is removed.
+	writeEntry("world")
+	binary4 := goext.Ok(BuildGoEntry("testdata/hello/main.go", false))
+	defer os.Remove(binary4)
+	assert.NotEqual(t, binary, binary4)
+	assert.False(t, util.Exists(binary))
+	assert.True(t, util.Exists(binary4))
+
+	out = goext.Ok(exec.Command(binary4).CombinedOutput())
+	assert.Equal(t, "world\n", string(out))
+}
+
+func TestBuildGoEntry_error(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("testdata/broken", 0755))
+	defer os.RemoveAll("testdata")
+	goext.Ok(0, os.WriteFile("testdata/broken/main.go", []byte("package main\n\nfunc main() {"), 0644))
+
+	_, err := BuildGoEntry("testdata/broken/main.go", false)
+	assert.Contains(t, err.Error(), "unable to build testdata/broken/main.go")
+	assert.Contains(t, err.Error(), "syntax error") // the output of `go build`
+}
+
+func TestHashGoSources_localReplaces(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("testdata/app", 0755))
+	goext.Ok(0, os.MkdirAll("testdata/lib", 0755))
+	defer os.RemoveAll("testdata")
+
+	goMod := "module example.com/app\n\ngo 1.21\n\nrequire example.com/lib v0.0.0\n\n" +
+		"replace example.com/lib => ../lib // local\n"
+	goext.Ok(0, os.WriteFile("testdata/app/go.mod", []byte(goMod), 0644))
+	goext.Ok(0, os.WriteFile("testdata/app/main.go", []byte("package main\n"), 0644))
+	goext.Ok(0, os.WriteFile("testdata/lib/lib.go", []byte("package lib\n"), 0644))
+
+	assert.Equal(t, []string{goext.Ok(filepath.Abs("testdata/lib"))},
+		findLocalReplaces(goext.Ok(filepath.Abs("testdata/app"))))
+
+	hash := goext.Ok(hashGoSources("testdata/app/main.go"))
+	goext.Ok(0, os.WriteFile("testdata/lib/lib.go", []byte("package lib\n\nvar A = 1\n"), 0644))
+	assert.NotEqual(t, hash, goext.Ok(hashGoSources("testdata/app/main.go")))
+}
+
+func TestHost_resolveGoBinary(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("testdata/hello", 0755))
+	defer os.RemoveAll("testdata")
+
+	writeEntry := func(text string) {
+		code := "package main\n\nfunc main() { println(\"" + text + "\") }\n"
+		goext.Ok(0, os.WriteFile("testdata/hello/main.go", []byte(code), 0644))
+	}
+
+	host := NewHost(config.Config{}, false)
+	app := config.App{Name: "hello", Entry: "testdata/hello/main.go"}
+
+	writeEntry("hello")
+	binary := goext.Ok(host.resolveGoBinary(app))
+	defer os.Remove(binary)
+
+	// The binary is reused without checking the sources, until a new one is built by the CLI.
+	writeEntry("world")
+	assert.Equal(t, binary, goext.Ok(host.resolveGoBinary(app)))
+
+	// The entry is built again if the binary is gone, e.g. removed by another build.
+	os.Remove(binary)
+	binary2 := goext.Ok(host.resolveGoBinary(app))
+	defer os.Remove(binary2)
+	assert.NotEqual(t, binary, binary2)
+
+	out := goext.Ok(exec.Command(binary2).CombinedOutput())
+	assert.Equal(t, "world\n", string(out))
+
+	binary3 := goext.Ok(host.resolveGoBinary(config.App{Name: "node-app", Entry: "main.js"}))
+	assert.Equal(t, "", binary3)
+}
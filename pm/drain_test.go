@@ -0,0 +1,175 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendCommand_drain(t *testing.T) {
+	conf := `{"apps":[` +
+		`{"name":"drain-app","url":"grpc://localhost:4030","serve":true,"entry":"main.go"},` +
+		`{"name":"other-app","url":"grpc://localhost:4031","serve":true,"entry":"main.go"}` +
+		`]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	defer os.Remove("ngrpc.json")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	drains := make(chan bool, 2)
+	stopped := make(chan string, 1)
+	var guest *Guest
+	app := config.App{Name: "drain-app", Url: "grpc://localhost:4030"}
+	guest = NewGuest(app, func(msgId string) {
+		guest.Leave("app [drain-app] stopped", msgId)
+		stopped <- msgId
+	})
+	guest.OnDrain(func(ctx context.Context, drain bool) error {
+		drains <- drain
+		return nil
+	})
+	guest.Join()
+	guest.Ready()
+
+	other := NewGuest(config.App{Name: "other-app", Url: "grpc://localhost:4031"}, nil)
+	other.Join()
+	other.Ready()
+	defer other.Leave("", "")
+
+	members := make(chan []string, 10)
+	watcher := goext.Ok(WatchApp("drain-app", func(urls []string) {
+		members <- urls
+	}))
+	defer watcher.Close()
+	assert.Equal(t, []string{"grpc://localhost:4030"}, <-members)
+
+	goext.Ok(0, SendCommand("drain", "drain-app"))
+	assert.True(t, <-drains)
+	assert.Equal(t, []string{}, <-members)
+	assert.True(t, other.IsDrained("drain-app"))
+
+	client, _ := host.findClient(func(item clientRecord) bool {
+		return item.App == "drain-app"
+	})
+	assert.True(t, client.Drained)
+
+	// A guest that joins later knows the instance is drained.
+	late := NewGuest(config.App{Name: "late-app"}, func(msgId string) {})
+	late.Join()
+	time.Sleep(time.Millisecond * 10)
+	assert.True(t, late.IsDrained("drain-app"))
+	late.Leave("", "")
+
+	goext.Ok(0, SendCommand("undrain", "drain-app"))
+	assert.False(t, <-drains)
+	assert.Equal(t, []string{"grpc://localhost:4030"}, <-members)
+	assert.False(t, other.IsDrained("drain-app"))
+
+	// Drain and stop.
+	goext.Ok(0, SendCommand("drain", "drain-app", CommandOptions{Stop: true}))
+	assert.True(t, <-drains)
+
+	select {
+	case msgId := <-stopped:
+		assert.NotEqual(t, "", msgId)
+	case <-time.After(time.Second):
+		t.Fatal("the app was not stopped")
+	}
+
+	// Once the drained instance leaves, it's no longer considered drained.
+	time.Sleep(time.Millisecond * 10)
+	assert.False(t, other.IsDrained("drain-app"))
+}
+
+func TestSendCommand_drainTimeout(t *testing.T) {
+	conf := `{"apps":[` +
+		`{"name":"hung-app","url":"grpc://localhost:4032","serve":true,"entry":"main.go"}` +
+		`]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	defer os.Remove("ngrpc.json")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	// The in-flight calls never finish.
+	stopped := make(chan string, 1)
+	guest := NewGuest(config.App{Name: "hung-app", Url: "grpc://localhost:4032"},
+		func(msgId string) {
+			stopped <- msgId
+		})
+	guest.OnDrain(func(ctx context.Context, drain bool) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	guest.Join()
+	guest.Ready()
+	defer guest.Leave("", "")
+	time.Sleep(time.Millisecond * 10)
+
+	start := time.Now()
+	reply := exchange(t, EncodeMessage(ControlMessage{
+		Cmd:     CmdDrain,
+		App:     "hung-app",
+		MsgId:   "abc",
+		Timeout: 100,
+	}))
+	assert.Equal(t, "abc", reply.MsgId)
+	assert.Equal(t, ErrTimeout, reply.Code)
+	assert.Equal(t, "app [hung-app] didn't finish the in-flight calls within 100ms", reply.Error)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// The CLI fails with the timeout error, and the app is not stopped since it's not drained.
+	err := SendCommand("drain", "hung-app", CommandOptions{Stop: true, Timeout: time.Millisecond * 100})
+	var protoErr *ProtocolError
+	assert.True(t, errors.As(err, &protoErr))
+	assert.Equal(t, ErrTimeout, protoErr.Code)
+
+	select {
+	case <-stopped:
+		t.Fatal("the app was stopped")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestSendCommand_drainError(t *testing.T) {
+	conf := `{"apps":[` +
+		`{"name":"faulty-app","url":"grpc://localhost:4033","serve":true,"entry":"main.go"}` +
+		`]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	defer os.Remove("ngrpc.json")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	guest := NewGuest(config.App{Name: "faulty-app", Url: "grpc://localhost:4033"}, nil)
+	guest.OnDrain(func(ctx context.Context, drain bool) error {
+		return errors.New("something went wrong")
+	})
+	guest.Join()
+	guest.Ready()
+	defer guest.Leave("", "")
+	time.Sleep(time.Millisecond * 10)
+
+	// The errors other than the timeout are reported as they are.
+	reply := exchange(t, EncodeMessage(ControlMessage{
+		Cmd:   CmdUndrain,
+		App:   "faulty-app",
+		MsgId: "abc",
+	}))
+	assert.Equal(t, "abc", reply.MsgId)
+	assert.Equal(t, ErrCommandFailed, reply.Code)
+	assert.Equal(t, "app [faulty-app] failed to undrain: something went wrong", reply.Error)
+}
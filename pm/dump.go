@@ -0,0 +1,232 @@
+package pm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/goext/stringx"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/util"
+)
+
+// dumpRecord is an app instance saved by `ngrpc save`.
+type dumpRecord struct {
+	App string            `json:"app"`
+	Env map[string]string `json:"env"`
+}
+
+type dumpFile struct {
+	SavedAt int          `json:"savedAt"`
+	Apps    []dumpRecord `json:"apps"`
+}
+
+// GetDumpPath returns the path of the file that `ngrpc save` writes the running apps to, which is
+// next to the socket file.
+func GetDumpPath() string {
+	confFile := util.AbsPath("ngrpc.json", false)
+	ext := filepath.Ext(confFile)
+	return stringx.Slice(confFile, 0, -len(ext)) + ".dump.json"
+}
+
+// loadDumpedApps reads the dump file and returns the config of the saved apps, with the env
+// restored. The apps that no longer exist in the config file are returned as `missing`.
+func loadDumpedApps(allApps []config.App) (apps []config.App, missing []string, err error) {
+	data, err := os.ReadFile(GetDumpPath())
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, errors.New("no saved apps, run `ngrpc save` first")
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var dump dumpFile
+
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, nil, fmt.Errorf("invalid dump file %s: %w", GetDumpPath(), err)
+	}
+
+	apps = []config.App{}
+	missing = []string{}
+
+	for _, record := range dump.Apps {
+		app, ok := slicex.Find(allApps, func(item config.App, _ int) bool {
+			return item.Name == record.App
+		})
+
+		if !ok {
+			missing = append(missing, record.App)
+			continue
+		}
+
+		app.Env = record.Env
+		apps = append(apps, app)
+	}
+
+	return apps, missing, nil
+}
+
+// listClients asks the host server for the apps that are currently running.
+//
+// NOTE: this function runs in the CLI instead of the host server.
+func listClients(guest *Guest) []clientRecord {
+	msgId := guest.request(ControlMessage{Cmd: CmdList})
+
+	for reply := range guest.replyChan {
+		if reply.Cmd == CmdReply && reply.MsgId == msgId {
+			return reply.Guests
+		}
+	}
+
+	return nil
+}
+
+// saveApps writes the apps that are currently running to the dump file, so they can be resurrected
+// after the host server or the machine restarts.
+//
+// NOTE: this function runs in the CLI instead of the host server.
+func (self *Host) saveApps(guest *Guest) error {
+	defer guest.Leave("", "")
+
+	conf, err := config.LoadConfig()
+
+	if err != nil {
+		return err
+	}
+
+	clients := listClients(guest)
+
+	// Save the apps in the order of the config file.
+	dump := dumpFile{SavedAt: int(time.Now().Unix()), Apps: []dumpRecord{}}
+
+	for _, app := range conf.Apps {
+		client, ok := slicex.Find(clients, func(item clientRecord, _ int) bool {
+			return item.App == app.Name
+		})
+
+		if !ok {
+			continue
+		}
+
+		// Save the env that the app is running with, which may differ from the config file, e.g.
+		// resurrected, unless the app is not spawned by the host server.
+		env := client.Env
+
+		if env == nil {
+			env = app.Env
+		}
+
+		dump.Apps = append(dump.Apps, dumpRecord{App: app.Name, Env: env})
+	}
+
+	data, _ := json.MarshalIndent(dump, "", "    ")
+
+	if err := os.WriteFile(GetDumpPath(), data, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("saved %d app(s) to %s", len(dump.Apps), GetDumpPath())
+	return nil
+}
+
+// resurrectApps starts the apps saved by `ngrpc save`, the apps that are already running are
+// skipped.
+//
+// NOTE: this function runs in the CLI instead of the host server.
+func (self *Host) resurrectApps(guest *Guest) error {
+	conf, err := config.LoadConfig()
+
+	if err != nil {
+		guest.Leave("", "")
+		return err
+	}
+
+	apps, missing, err := loadDumpedApps(conf.Apps)
+
+	if err != nil {
+		guest.Leave("", "")
+		return err
+	}
+
+	for _, name := range missing {
+		fmt.Printf("app [%s] no longer exists in the config file, skipped\n", name)
+	}
+
+	clients := listClients(guest)
+	apps = slicex.Filter(apps, func(app config.App, _ int) bool {
+		return !slicex.Some(clients, func(item clientRecord, _ int) bool {
+			return item.App == app.Name
+		})
+	})
+
+	if len(apps) == 0 {
+		log.Println("all saved apps are running")
+		guest.Leave("", "")
+		return nil
+	} else if err := self.prepareApps(apps); err != nil {
+		guest.Leave("", "")
+		return err
+	}
+
+	return self.startApps(apps, guest)
+}
+
+// Resurrect respawns the apps saved by `ngrpc save` in the order of their dependencies, it's used
+// when the host server starts with `ngrpc host --resurrect`, e.g. on boot.
+func (self *Host) Resurrect() error {
+	apps, missing, err := loadDumpedApps(self.getApps())
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range missing {
+		log.Printf("app [%s] no longer exists in the config file, skipped", name)
+	}
+
+	groups, err := config.GroupApps(apps)
+
+	if err != nil {
+		return err
+	} else if err := self.prepareApps(apps); err != nil {
+		return err
+	}
+
+	// An app is only spawned after all the apps it depends on are online, or failed to start
+	// within their `startTimeout`.
+	for _, group := range groups {
+		spawned := []config.App{}
+
+		for _, app := range group {
+			if self.isSupervised(app.Name) {
+				continue
+			} else if pid, err := self.spawnApp(app); err != nil {
+				log.Printf("unable to resurrect app [%s] (reason: %v)", app.Name, err)
+			} else {
+				log.Printf("app [%s] resurrected (pid: %d)", app.Name, pid)
+				spawned = append(spawned, app)
+			}
+		}
+
+		for _, app := range spawned {
+			deadline := time.Now().Add(getStartTimeout(app))
+
+			for self.state.Load() == 1 && time.Now().Before(deadline) {
+				if _, ok := self.findClient(func(item clientRecord) bool {
+					return item.App == app.Name && item.Ready
+				}); ok {
+					break
+				}
+
+				time.Sleep(time.Millisecond * 100)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendCommand_save(t *testing.T) {
+	conf := `{"apps":[` +
+		`{"name":"save-app","url":"grpc://localhost:{4040+i}","serve":true,"instances":2,` +
+		`"entry":"main.go","env":{"FOO":"bar"}},` +
+		`{"name":"idle-app","url":"grpc://localhost:4042","serve":true,"entry":"main.go"},` +
+		`{"name":"env-app","url":"grpc://localhost:4045","serve":true,"entry":"sleep.sh",` +
+		`"env":{"FOO":"bar"}}` +
+		`]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	goext.Ok(0, os.WriteFile("sleep.sh", []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("sleep.sh")
+	defer os.Remove(GetDumpPath())
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	guest := NewGuest(config.App{Name: "save-app#1", Url: "grpc://localhost:4041"}, nil)
+	guest.Join()
+	defer guest.Leave("", "")
+
+	// The app spawned with another env, e.g. resurrected, is saved with the env it runs with.
+	app := host.apps[3]
+	app.Env = map[string]string{"FOO": "override"}
+	pid := goext.Ok(host.spawnApp(app))
+	conn := joinSilently("env-app", pid, &atomic.Bool{})
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 50)
+
+	goext.Ok(0, SendCommand("save", ""))
+	assert.True(t, strings.HasSuffix(GetDumpPath(), "/pm/ngrpc.dump.json"))
+
+	var dump dumpFile
+	goext.Ok(0, json.Unmarshal(goext.Ok(os.ReadFile(GetDumpPath())), &dump))
+	assert.Equal(t, []dumpRecord{
+		{App: "save-app#1", Env: map[string]string{"FOO": "bar"}},
+		{App: "env-app", Env: map[string]string{"FOO": "override"}},
+	}, dump.Apps)
+	assert.NotEqual(t, 0, dump.SavedAt)
+
+	host.markStopping("env-app")
+	killProcess(pid)
+	time.Sleep(time.Millisecond * 100) // wait for the exit to be handled
+}
+
+func TestHost_Resurrect(t *testing.T) {
+	conf := `{"apps":[` +
+		`{"name":"dead-app","url":"grpc://localhost:4043","serve":true,"entry":"env.sh",` +
+		`"env":{"FOO":"baz"},"startTimeout":100},` +
+		`{"name":"front-app","url":"grpc://localhost:4044","serve":true,"entry":"env.sh",` +
+		`"dependsOn":["dead-app"],"startTimeout":100}` +
+		`]}`
+	dump := `{"apps":[` +
+		`{"app":"front-app","env":{"FOO":"front"}},` +
+		`{"app":"dead-app","env":{"FOO":"bar"}},` +
+		`{"app":"removed-app","env":null}` +
+		`]}`
+	script := "#!/bin/sh\necho \"$1 $FOO $(date +%s%N)\" >> env.txt\nexec sleep 10\n"
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	goext.Ok(0, os.WriteFile(GetDumpPath(), []byte(dump), 0644))
+	goext.Ok(0, os.WriteFile("env.sh", []byte(script), 0755))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove(GetDumpPath())
+	defer os.Remove("env.sh")
+	defer os.Remove("env.txt")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	goext.Ok(0, host.Resurrect())
+	time.Sleep(time.Millisecond * 50)
+
+	assert.True(t, host.isSupervised("dead-app"))
+	assert.True(t, host.isSupervised("front-app"))
+	assert.False(t, host.isSupervised("removed-app"))
+
+	// The apps are spawned in the order of their dependencies, with the saved env.
+	lines := strings.Split(strings.TrimSpace(string(goext.Ok(os.ReadFile("env.txt")))), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "dead-app bar "))
+	assert.True(t, strings.HasPrefix(lines[1], "front-app front "))
+
+	for _, name := range []string{"dead-app", "front-app"} {
+		host.processesLock.Lock()
+		record := host.processes[name]
+		host.processesLock.Unlock()
+		host.markStopping(name)
+		record.cmd.Process.Kill()
+	}
+
+	// No dump file.
+	os.Remove(GetDumpPath())
+	assert.EqualError(t, host.Resurrect(), "no saved apps, run `ngrpc save` first")
+}
@@ -0,0 +1,246 @@
+package pm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/pm/socket"
+)
+
+// EventType is the type of a lifecycle event of an app.
+type EventType string
+
+const (
+	// The app has finished its initialization and is ready to serve.
+	EventOnline EventType = "online"
+	// The app has left the group gracefully, e.g. it's stopped by the `stop` command.
+	EventOffline EventType = "offline"
+	// The app has exited or lost its connection unexpectedly.
+	EventCrashed EventType = "crashed"
+	// The host server has spawned the app again, after a crash or a restart triggered by the
+	// monitor, the app comes online again later.
+	EventRestarted EventType = "restarted"
+	// The app has been hot-reloaded by the `reload` command.
+	EventReloaded EventType = "reloaded"
+	// The app has failed the health checks and is going to be restarted.
+	EventUnhealthy EventType = "unhealthy"
+	// The app has missed several heartbeats in a row, it may be hung.
+	EventUnresponsive EventType = "unresponsive"
+)
+
+// AppEvent is a lifecycle event of an app published by the host server.
+type AppEvent struct {
+	Type EventType `json:"type"`
+	App  string    `json:"app"`
+	Pid  int       `json:"pid,omitempty"`
+	// The time of the event in Unix milliseconds.
+	Time int64 `json:"time"`
+	// Why the event happened, e.g. the exit code of a crashed app.
+	Reason string `json:"reason,omitempty"`
+}
+
+func (self AppEvent) String() string {
+	str := fmt.Sprintf("%s app [%s] %s", time.UnixMilli(self.Time).Format(time.DateTime),
+		self.App, self.Type)
+
+	if self.Pid > 0 {
+		str += fmt.Sprintf(" (pid: %d)", self.Pid)
+	}
+
+	if self.Reason != "" {
+		str += ": " + self.Reason
+	}
+
+	return str
+}
+
+type subscriberRecord struct {
+	conn net.Conn
+	// The `MsgId` of the `subscribe` command, which the `event`s are correlated by.
+	msgId string
+}
+
+func (self *Host) addSubscriber(subscriber subscriberRecord) {
+	self.subscribersLock.Lock()
+	self.subscribers = append(self.subscribers, subscriber)
+	self.subscribersLock.Unlock()
+}
+
+func (self *Host) removeSubscriber(conn net.Conn) {
+	self.subscribersLock.Lock()
+	self.subscribers = slicex.Filter(self.subscribers, func(item subscriberRecord, _ int) bool {
+		return item.conn != conn
+	})
+	self.subscribersLock.Unlock()
+}
+
+// publishEvent sends the event of the app to all the subscribers, the events are not published
+// once the host server starts shutting down.
+func (self *Host) publishEvent(eventType EventType, appName string, pid int, reason string) {
+	if self.state.Load() != 1 || appName == "" || appName == ":cli" {
+		return
+	}
+
+	event := &AppEvent{
+		Type:   eventType,
+		App:    appName,
+		Pid:    pid,
+		Time:   time.Now().UnixMilli(),
+		Reason: reason,
+	}
+
+	// Hold the lock while writing, so all the subscribers receive the events in the same order.
+	self.subscribersLock.Lock()
+	defer self.subscribersLock.Unlock()
+
+	for _, subscriber := range self.subscribers {
+		subscriber.conn.Write(EncodeMessage(ControlMessage{
+			Cmd:   CmdEvent,
+			App:   appName,
+			MsgId: subscriber.msgId,
+			Event: event,
+		}))
+	}
+}
+
+// EventStream subscribes to the host server for the lifecycle events of the apps.
+type EventStream struct {
+	conn    net.Conn
+	onEvent func(event AppEvent)
+	done    chan struct{}
+	err     error
+}
+
+// SubscribeEvents subscribes to the lifecycle events of all apps, `onEvent` is called in order for
+// each event published by the host server after the subscription is established.
+//
+// Unlike `WatchApp()`, the stream doesn't reconnect, since the apps are stopped along with the host
+// server, use `Wait()` to know when the stream ends.
+func SubscribeEvents(onEvent func(event AppEvent)) (*EventStream, error) {
+	_, sockPath := GetSocketPath()
+
+	if !IsHostOnline() {
+		return nil, errors.New("host server is not running")
+	}
+
+	conn, err := socket.DialTimeout(sockPath, time.Second)
+
+	if err != nil {
+		return nil, err
+	}
+
+	msgId := newMsgId()
+	_, err = conn.Write(EncodeMessage(ControlMessage{
+		Cmd:     CmdSubscribe,
+		MsgId:   msgId,
+		Version: ProtocolVersion,
+	}))
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	stream := &EventStream{conn: conn, onEvent: onEvent, done: make(chan struct{})}
+	subscribed := make(chan error, 1)
+
+	go func() {
+		packet := []byte{}
+		buf := make([]byte, 256)
+		acked := false
+		var result error
+
+		for result == nil {
+			n, err := conn.Read(buf)
+
+			for _, msg := range DecodeMessage(&packet, buf[:n], err != nil) {
+				if msg.Cmd == CmdGoodbye {
+					result = errors.New("host server shut down")
+				} else if msg.MsgId != msgId {
+					continue
+				} else if !acked {
+					acked = true
+					subscribed <- msg.Err()
+
+					if msg.Error != "" {
+						result = msg.Err() // rejected, e.g. the host server is of another version
+					}
+				} else if msg.Cmd == CmdEvent && msg.Event != nil {
+					stream.onEvent(*msg.Event)
+				}
+			}
+
+			if err != nil && result == nil {
+				if errors.Is(err, io.EOF) {
+					result = errors.New("host server shut down")
+				} else {
+					result = err
+				}
+			}
+		}
+
+		conn.Close()
+
+		if !acked {
+			subscribed <- result
+		}
+
+		stream.err = result
+		close(stream.done)
+	}()
+
+	select {
+	case err := <-subscribed:
+		if err != nil {
+			return nil, err
+		}
+	case <-time.After(time.Second * 5):
+		conn.Close()
+		return nil, errors.New("the host server didn't acknowledge the subscription")
+	}
+
+	return stream, nil
+}
+
+// Wait blocks until the stream ends, it returns the reason why the stream ended, e.g. the host
+// server has shut down, or `net.ErrClosed` if the stream is closed by `Close()`.
+func (self *EventStream) Wait() error {
+	<-self.done
+	return self.err
+}
+
+// Close unsubscribes the events and closes the connection to the host server.
+func (self *EventStream) Close() {
+	self.conn.Close()
+}
+
+// PrintEvents prints the lifecycle events of the apps as they happen, in JSON lines if `asJson` is
+// set, until the context is done or the host server shuts down.
+func PrintEvents(ctx context.Context, out io.Writer, asJson bool) error {
+	stream, err := SubscribeEvents(func(event AppEvent) {
+		if asJson {
+			buf, _ := json.Marshal(event)
+			fmt.Fprintln(out, string(buf))
+		} else {
+			fmt.Fprintln(out, event.String())
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		stream.Close()
+		return nil
+	case <-stream.done:
+		return stream.err
+	}
+}
@@ -0,0 +1,186 @@
+package pm
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/pm/socket"
+	"github.com/ayonli/ngrpc/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func waitEvent(t *testing.T, events chan AppEvent) AppEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second * 2):
+		t.Fatal("no event received")
+		return AppEvent{}
+	}
+}
+
+func TestAppEvent_String(t *testing.T) {
+	now := time.Now().UnixMilli()
+	event := AppEvent{
+		Type:   EventCrashed,
+		App:    "user-server",
+		Pid:    1234,
+		Time:   now,
+		Reason: "exited with code 1",
+	}
+	assert.Equal(t, time.UnixMilli(now).Format(time.DateTime)+
+		" app [user-server] crashed (pid: 1234): exited with code 1", event.String())
+
+	event = AppEvent{Type: EventOnline, App: "user-server", Time: now}
+	assert.Equal(t, time.UnixMilli(now).Format(time.DateTime)+" app [user-server] online",
+		event.String())
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	defer os.Remove("ngrpc.json")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	// Every subscriber receives the events.
+	events1 := make(chan AppEvent, 10)
+	events2 := make(chan AppEvent, 10)
+	stream1 := goext.Ok(SubscribeEvents(func(event AppEvent) { events1 <- event }))
+	stream2 := goext.Ok(SubscribeEvents(func(event AppEvent) { events2 <- event }))
+
+	guest := NewGuest(config.App{Name: "events-app"}, func(msgId string) {})
+	guest.Join()
+	guest.Ready()
+
+	for _, events := range []chan AppEvent{events1, events2} {
+		event := waitEvent(t, events)
+		assert.Equal(t, EventOnline, event.Type)
+		assert.Equal(t, "events-app", event.App)
+		assert.Equal(t, os.Getpid(), event.Pid)
+		assert.InDelta(t, time.Now().UnixMilli(), event.Time, 2000)
+	}
+
+	// The closed stream is no longer notified.
+	stream2.Close()
+	assert.ErrorIs(t, stream2.Wait(), net.ErrClosed)
+
+	guest.Leave("", "")
+	event := waitEvent(t, events1)
+	assert.Equal(t, EventOffline, event.Type)
+	assert.Equal(t, "events-app", event.App)
+
+	// An app that loses the connection without saying goodbye is considered crashed.
+	_, sockPath := GetSocketPath()
+	conn := goext.Ok(socket.DialTimeout(sockPath, time.Second))
+	conn.Write(EncodeMessage(ControlMessage{
+		Cmd:     CmdHandshake,
+		App:     "events-app",
+		Pid:     12345,
+		Version: ProtocolVersion,
+	}))
+	conn.Write(EncodeMessage(ControlMessage{Cmd: CmdReady, App: "events-app"}))
+	assert.Equal(t, EventOnline, waitEvent(t, events1).Type)
+	conn.Close()
+
+	event = waitEvent(t, events1)
+	assert.Equal(t, EventCrashed, event.Type)
+	assert.Equal(t, 12345, event.Pid)
+	assert.Equal(t, "exited accidentally", event.Reason)
+
+	host.Stop()
+	assert.Equal(t, "host server shut down", stream1.Wait().Error())
+	assert.Equal(t, 0, len(events1))
+	assert.Equal(t, 0, len(events2))
+}
+
+// joinReloadable joins the host server as the app instance which accepts the `reload` command.
+func joinReloadable(appName string, pid int) net.Conn {
+	_, sockPath := GetSocketPath()
+	conn := goext.Ok(socket.DialTimeout(sockPath, time.Second))
+	conn.Write(EncodeMessage(ControlMessage{
+		Cmd:     CmdHandshake,
+		App:     appName,
+		Pid:     pid,
+		Version: ProtocolVersion,
+	}))
+	conn.Write(EncodeMessage(ControlMessage{Cmd: CmdReady, App: appName}))
+
+	go func() {
+		packet := []byte{}
+		buf := make([]byte, 256)
+
+		for {
+			n, err := conn.Read(buf)
+
+			for _, msg := range DecodeMessage(&packet, buf[:n], err != nil) {
+				if msg.Cmd == CmdReload {
+					conn.Write(EncodeMessage(ControlMessage{
+						Cmd:   CmdReply,
+						App:   appName,
+						MsgId: msg.MsgId,
+					}))
+				}
+			}
+
+			if err != nil {
+				break
+			}
+		}
+	}()
+
+	return conn
+}
+
+func TestSubscribeEvents_reload(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	defer os.Remove("ngrpc.json")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	events := make(chan AppEvent, 10)
+	stream := goext.Ok(SubscribeEvents(func(event AppEvent) { events <- event }))
+	defer stream.Close()
+
+	conn1 := joinReloadable("reload-app#0", 12345)
+	defer conn1.Close()
+	assert.Equal(t, EventOnline, waitEvent(t, events).Type)
+
+	conn2 := joinReloadable("reload-app#1", 12346)
+	defer conn2.Close()
+	assert.Equal(t, EventOnline, waitEvent(t, events).Type)
+
+	exchange(t, EncodeMessage(ControlMessage{Cmd: CmdReload, App: "reload-app", MsgId: "abc"}))
+
+	// Each instance is reported with its own name and pid.
+	reloaded := map[string]int{}
+
+	for i := 0; i < 2; i++ {
+		event := waitEvent(t, events)
+		assert.Equal(t, EventReloaded, event.Type)
+		reloaded[event.App] = event.Pid
+	}
+
+	assert.Equal(t, map[string]int{"reload-app#0": 12345, "reload-app#1": 12346}, reloaded)
+}
+
+func TestSubscribeEvents_versionMismatch(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	defer os.Remove("ngrpc.json")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	reply := exchange(t, EncodeMessage(ControlMessage{Cmd: CmdSubscribe, MsgId: "abc"}))
+	assert.Equal(t, "abc", reply.MsgId)
+	assert.Equal(t, ErrVersionMismatch, reply.Code)
+	assert.Equal(t, 0, len(host.subscribers))
+}
@@ -1,6 +1,7 @@
 package pm
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ayonli/goext/slicex"
@@ -20,16 +23,40 @@ import (
 	"github.com/ayonli/ngrpc/util"
 )
 
+// ControlMessage is the message exchanged over the host socket, delimited by `\n`, see `Command`
+// for the fields used by each command.
 type ControlMessage struct {
-	Cmd    string         `json:"cmd"`
+	Cmd    Command        `json:"cmd"`
 	App    string         `json:"app"`
 	MsgId  string         `json:"msgId"`
 	Text   string         `json:"text"`
 	Guests []clientRecord `json:"guests"`
 	Error  string         `json:"error"`
+	// `Code` tells the kind of the `Error`.
+	Code ErrorCode `json:"code,omitempty"`
 
+	// `Restarts` is replied along with `Guests` when `Cmd` is `list`.
+	Restarts []restartRecord `json:"restarts"`
+
+	// `Version` is the protocol version, which is exchanged when `Cmd` is `handshake`, or sent
+	// along with the `watch` and `resolve` commands, which don't handshake.
+	Version int `json:"version,omitempty"`
 	// `Pid` shall be provided when `Cmd` is `handshake`.
 	Pid int `json:"pid"`
+	// `Url` is the actual URL the app serves, which is provided when `Cmd` is `handshake`, and
+	// replied when `Cmd` is `resolve`.
+	Url string `json:"url"`
+	// `Env` overrides the env of the app in the config file when `Cmd` is `spawn`.
+	Env map[string]string `json:"env"`
+	// `Binary` is the binary of a Go app built by the CLI, sent along with the `spawn` command, so
+	// the host server doesn't build the entry again.
+	Binary string `json:"binary,omitempty"`
+	// `Event` is published to the subscribers when `Cmd` is `event`.
+	Event *AppEvent `json:"event,omitempty"`
+	// `Timeout` (in milliseconds) and `Force` are sent along with the `stop` command by the CLI, see
+	// `stopClient()`, `Timeout` is sent along with the `drain` command as well.
+	Timeout int  `json:"timeout,omitempty"`
+	Force   bool `json:"force,omitempty"`
 
 	// `conn.Close()` will destroy the connection before the final message is flushed, causing the
 	// other peer losing the connection and the message, and no EOF will be received. To guarantee
@@ -45,41 +72,10 @@ func EncodeMessage(msg ControlMessage) []byte {
 	return buf
 }
 
+// DecodeMessage decodes the messages from the packet and the buffer read from the connection, the
+// unfinished message is kept in the packet for more data, and the invalid ones are dropped.
 func DecodeMessage(packet *[]byte, bufRead []byte, eof bool) []ControlMessage {
-	*packet = append(*packet, bufRead...)
-	chunks := slicex.Split(*packet, byte('\n'))
-
-	if eof {
-		// Empty the packet when reaching EOF.
-		*packet = []byte{}
-		// Returns all non-empty chunks, normally the last chunk is empty.
-		chunks = slicex.Filter(chunks, func(chunk []byte, _ int) bool {
-			return len(chunk) > 0
-		})
-	} else if len(chunks) > 1 {
-		// The last chunk is unfinished, we store it in the packet for more data.
-		*packet = chunks[len(chunks)-1]
-		// All chunks (except the last one) will be processed.
-		chunks = chunks[:len(chunks)-1]
-	} else { // len(chunk) == 1
-		// We use `\n` to delimit message packets, each packet ends with a `\n`, when len(chunks)
-		// is 1, it means that the delimiter haven't been received and there is more buffers needs
-		// to be received, no available chunks for consuming yet.
-		return nil
-	}
-
-	messages := []ControlMessage{}
-
-	for _, chunk := range chunks {
-		var msg ControlMessage
-
-		if err := json.Unmarshal(chunk, &msg); err != nil {
-			continue
-		} else {
-			messages = append(messages, msg)
-		}
-	}
-
+	messages, _ := decodeMessages(packet, bufRead, eof)
 	return messages
 }
 
@@ -110,35 +106,126 @@ func IsHostOnline() bool {
 	}
 }
 
+// ResolveAppUrl asks the host server for the actual URL of a running app, this is used when the app
+// is served on a dynamic port (port `0`) and the URL in the config file is not the real one.
+func ResolveAppUrl(appName string) (string, error) {
+	_, sockPath := GetSocketPath()
+
+	if !IsHostOnline() {
+		return "", errors.New("host server is not running")
+	}
+
+	conn, err := socket.DialTimeout(sockPath, time.Second)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write(EncodeMessage(ControlMessage{
+		Cmd:     CmdResolve,
+		App:     appName,
+		MsgId:   newMsgId(),
+		Version: ProtocolVersion,
+	}))
+
+	if err != nil {
+		return "", err
+	}
+
+	packet := []byte{}
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	for {
+		n, err := conn.Read(buf)
+
+		for _, msg := range DecodeMessage(&packet, buf[:n], err != nil) {
+			if err := msg.Err(); err != nil {
+				return "", err
+			} else if msg.Fin {
+				return msg.Url, nil
+			}
+		}
+
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
 type Guest struct {
 	AppName string
 	AppUrl  string
 	conn    net.Conn
 	// 0: disconnected; 1: connected; 2: closed
-	state             int
-	handleStopCommand func(msgId string)
-	replyChan         chan ControlMessage
-	cancelSignal      chan bool
+	state atomic.Int32
+	// The app has finished its initialization, see `Ready()`.
+	ready atomic.Bool
+	// The last time a message is received from the host server in Unix nanoseconds, see
+	// `heartbeat()`.
+	lastSeen           atomic.Int64
+	heartbeatInterval  time.Duration
+	heartbeatMaxMisses int
+	handleStopCommand  func(msgId string)
+	handleDrainCommand func(ctx context.Context, drain bool) error
+	replyChan          chan ControlMessage
+	// Closed once the guest leaves, which stops the reconnection.
+	done      chan struct{}
+	leaveOnce sync.Once
+	connLock  sync.Mutex
+	// The app instances that are drained, which shall not be selected by the load balancer.
+	drained     map[string]bool
+	drainedLock sync.RWMutex
 }
 
 func NewGuest(app config.App, onStopCommand func(msgId string)) *Guest {
+	interval, maxMisses, _ := getHeartbeatOptions(app)
 	guest := &Guest{
-		AppName:           app.Name,
-		AppUrl:            app.Url,
-		handleStopCommand: onStopCommand,
+		AppName:            app.Name,
+		AppUrl:             app.Url,
+		handleStopCommand:  onStopCommand,
+		done:               make(chan struct{}),
+		drained:            map[string]bool{},
+		heartbeatInterval:  interval,
+		heartbeatMaxMisses: maxMisses,
 	}
 
 	return guest
 }
 
+// OnDrain registers a handler for the `drain` and `undrain` commands targeting this app. When
+// draining, the handler shall stop accepting new traffic and return after the in-flight calls
+// finish, or return `ctx.Err()` if they don't finish before the `ctx` is done. When undraining, it
+// shall resume accepting traffic.
+func (self *Guest) OnDrain(handler func(ctx context.Context, drain bool) error) {
+	self.handleDrainCommand = handler
+}
+
+// IsDrained reports whether the app instance has been drained, so it should be removed from the
+// rotation of the load balancer.
+func (self *Guest) IsDrained(appName string) bool {
+	self.drainedLock.RLock()
+	defer self.drainedLock.RUnlock()
+	return self.drained[appName]
+}
+
 func (self *Guest) Join() {
 	err := self.connect()
 
-	if err != nil { // auto-reconnect in the background
+	if isVersionMismatch(err) {
+		log.Println(err)
+	} else if err != nil { // auto-reconnect in the background
 		go self.reconnect()
 	}
 }
 
+func isVersionMismatch(err error) bool {
+	var protoErr *ProtocolError
+	return errors.As(err, &protoErr) && protoErr.Code == ErrVersionMismatch
+}
+
 func (self *Guest) connect() error {
 	sockFile, sockPath := GetSocketPath()
 
@@ -156,9 +243,11 @@ func (self *Guest) connect() error {
 	}
 
 	msg := ControlMessage{
-		Cmd: "handshake",
-		App: self.AppName,
-		Pid: os.Getpid(),
+		Cmd:     CmdHandshake,
+		App:     self.AppName,
+		Pid:     os.Getpid(),
+		Url:     self.AppUrl,
+		Version: ProtocolVersion,
 	}
 
 	_, err = conn.Write(EncodeMessage(msg))
@@ -168,8 +257,8 @@ func (self *Guest) connect() error {
 		return err
 	}
 
-	self.conn = conn
-	handshake := make(chan bool)
+	self.setConn(conn)
+	handshake := make(chan error)
 
 	go func() {
 		packet := []byte{}
@@ -194,17 +283,43 @@ func (self *Guest) connect() error {
 		}
 	}()
 
-	<-handshake
+	if err := <-handshake; err != nil {
+		self.setConn(nil)
+		conn.Close()
+		return err
+	}
 
 	if self.AppName != "" && self.AppName != ":cli" {
 		log.Printf("app [%s] has joined the group", self.AppName)
 	}
 
+	self.lastSeen.Store(time.Now().UnixNano())
+	go self.heartbeat(conn)
+
+	if self.ready.Load() {
+		// Reconnected to the host server, restore the readiness.
+		self.Send(ControlMessage{Cmd: CmdReady, App: self.AppName})
+	}
+
 	return nil
 }
 
+// Ready notifies the host server that the app has finished its initialization and is ready to
+// serve. If the guest is not connected yet, the notification is sent once it joins the group.
+func (self *Guest) Ready() {
+	self.ready.Store(true)
+
+	if self.state.Load() == 1 && self.getConn() != nil {
+		self.Send(ControlMessage{Cmd: CmdReady, App: self.AppName})
+	}
+}
+
 func (self *Guest) Leave(reason string, replyId string) bool {
-	if self.conn != nil {
+	// Mark the guest closed before saying goodbye, so the disconnection that follows will not be
+	// taken as an accident.
+	ok := self.state.Swap(2) == 1
+
+	if self.getConn() != nil {
 		if replyId != "" {
 			// If `replyId` is provided, that means the stop event is issued by a guest app, for
 			// example, the CLI tool, in this case, we need to send feedback to acknowledge the
@@ -214,97 +329,198 @@ func (self *Guest) Leave(reason string, replyId string) bool {
 			// if we sent the messages one by one continuously, go-winio cannot receive them
 			// well. So we send them in one packet, allowing the host server to separate them
 			// when received as a whole.
-			self.Send(ControlMessage{Cmd: "goodbye", App: self.AppName}, ControlMessage{
-				Cmd:   "reply",
+			self.Send(ControlMessage{Cmd: CmdGoodbye, App: self.AppName}, ControlMessage{
+				Cmd:   CmdReply,
 				App:   self.AppName,
 				MsgId: replyId,
 				Text:  reason,
 				Fin:   true,
 			})
 		} else {
-			self.Send(ControlMessage{Cmd: "goodbye", App: self.AppName, Fin: true})
+			self.Send(ControlMessage{Cmd: CmdGoodbye, App: self.AppName, Fin: true})
 		}
-	} else if self.cancelSignal != nil {
-		self.cancelSignal <- true
 	}
 
-	ok := self.state == 1
-	self.state = 2
+	self.leaveOnce.Do(func() {
+		close(self.done)
+	})
+
 	return ok
 }
 
+func (self *Guest) getConn() net.Conn {
+	self.connLock.Lock()
+	defer self.connLock.Unlock()
+	return self.conn
+}
+
+func (self *Guest) setConn(conn net.Conn) {
+	self.connLock.Lock()
+	defer self.connLock.Unlock()
+	self.conn = conn
+}
+
+// request sends the request with a `MsgId` (generated if not set), which the replies are correlated
+// by, and returns the `MsgId`.
+func (self *Guest) request(msg ControlMessage) string {
+	if msg.MsgId == "" {
+		msg.MsgId = newMsgId()
+	}
+
+	self.Send(msg)
+	return msg.MsgId
+}
+
 func (self *Guest) Send(msg ...ControlMessage) error {
 	packet := slicex.Flat(slicex.Map(msg, func(chunk ControlMessage, _ int) []byte {
 		return EncodeMessage(chunk)
 	}))
-	_, err := self.conn.Write(packet)
+	conn := self.getConn()
+
+	if conn == nil {
+		return errors.New("host server is not connected")
+	}
+
+	_, err := conn.Write(packet)
 	return err
 }
 
 func (self *Guest) reconnect() {
-	self.cancelSignal = make(chan bool)
 loop:
-	for self.state == 0 {
+	for self.state.Load() == 0 {
 		select {
 		case <-time.After(time.Second):
-			if self.state == 2 {
+			if self.state.Load() == 2 {
 				break loop
 			} else {
 				err := self.connect()
 
 				if err == nil {
 					break loop
+				} else if isVersionMismatch(err) {
+					log.Println(err)
+					break loop
 				}
 			}
-		case <-self.cancelSignal:
-			close(self.cancelSignal)
+		case <-self.done:
 			break loop
 		}
 	}
 }
 
 func (self *Guest) handleHostDisconnection() {
-	if self.state == 0 {
-		return
-	} else if self.state != 2 {
-		self.state = 0
+	// Only reconnect if the guest was connected, not disconnected or closed.
+	if self.state.CompareAndSwap(1, 0) {
 		self.reconnect()
 	}
 }
 
 func (self *Guest) processHostMessage(
-	handshake chan bool,
+	handshake chan error,
 	packet *[]byte,
 	bufRead []byte,
 	eof bool,
 ) {
 	for _, msg := range DecodeMessage(packet, bufRead, eof) {
+		// Any message proves that the host server is alive.
+		self.lastSeen.Store(time.Now().UnixNano())
 		self.handleMessage(handshake, msg)
 	}
 }
 
-func (self *Guest) handleMessage(handshake chan bool, msg ControlMessage) {
-	if msg.Cmd == "handshake" {
-		self.state = 1
-		handshake <- true
+func (self *Guest) handleMessage(handshake chan error, msg ControlMessage) {
+	if msg.Cmd == CmdHandshake {
+		err := msg.Err()
+
+		if err == nil && getVersion(msg) != ProtocolVersion {
+			// The host server is of another version that doesn't check the version.
+			err = newVersionMismatchError(getVersion(msg), ProtocolVersion)
+		}
+
+		if err == nil {
+			self.state.CompareAndSwap(0, 1) // unless the guest has left
+		}
+
+		handshake <- err
 		close(handshake)
-	} else if msg.Cmd == "goodbye" {
-		self.conn.Close()
+	} else if msg.Cmd == CmdGoodbye {
+		self.getConn().Close()
 
 		if self.replyChan != nil {
 			self.replyChan <- msg
 		}
-	} else if msg.Cmd == "stop" {
+	} else if msg.Cmd == CmdStop {
 		self.handleStopCommand(msg.MsgId)
-	} else if msg.Cmd == "reload" {
-		self.Send(ControlMessage{
-			Cmd:   "reply",
-			MsgId: msg.MsgId,
-			Text:  fmt.Sprintf("app [%v] does not support hot-reloading", self.AppName),
-		})
-	} else if msg.Cmd == "reply" || msg.Cmd == "online" {
+	} else if msg.Cmd == CmdPing {
+		// The host server probes the guest periodically to check if it's still responsive.
+		self.Send(ControlMessage{Cmd: CmdPong, MsgId: msg.MsgId})
+	} else if msg.Cmd == CmdPong {
+		// The host server responds to the heartbeat, see `heartbeat()`.
+	} else if msg.Cmd == CmdDrain || msg.Cmd == CmdUndrain {
+		self.handleDrain(msg)
+	} else if msg.Cmd == CmdReload {
+		self.Send(newErrorReply(ControlMessage{App: self.AppName, MsgId: msg.MsgId},
+			newProtocolError(ErrUnsupported, "app [%v] does not support hot-reloading",
+				self.AppName)))
+	} else if msg.Cmd == CmdReply || msg.Cmd == CmdOnline {
 		if self.replyChan != nil {
 			self.replyChan <- msg
 		}
+	} else if msg.MsgId != "" {
+		self.Send(newErrorReply(ControlMessage{App: self.AppName, MsgId: msg.MsgId},
+			newProtocolError(ErrUnknownCommand, "unknown command '%s'", msg.Cmd)))
+	}
+}
+
+// handleDrain handles the `drain` and `undrain` commands. The ones with a `msgId` target this app
+// and are replied once done, the others are broadcast by the host server to tell which instance is
+// drained.
+func (self *Guest) handleDrain(msg ControlMessage) {
+	drain := msg.Cmd == CmdDrain
+
+	if msg.MsgId == "" {
+		self.drainedLock.Lock()
+		if drain {
+			self.drained[msg.App] = true
+		} else {
+			delete(self.drained, msg.App)
+		}
+		self.drainedLock.Unlock()
+		return
 	}
+
+	// Waiting for the in-flight calls may take a while, don't block the messages of the connection.
+	go func() {
+		timeout := time.Duration(msg.Timeout) * time.Millisecond
+
+		if timeout <= 0 {
+			timeout = defaultDrainTimeout
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req := ControlMessage{App: self.AppName, MsgId: msg.MsgId}
+		var reply ControlMessage
+		var err error
+
+		if self.handleDrainCommand != nil {
+			err = self.handleDrainCommand(ctx, drain)
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			reply = newErrorReply(req, newProtocolError(ErrTimeout,
+				"app [%v] didn't finish the in-flight calls within %v", self.AppName, timeout))
+		} else if err != nil {
+			reply = newErrorReply(req, newProtocolError(ErrCommandFailed,
+				"app [%v] failed to %v: %v", self.AppName, msg.Cmd, err))
+		} else {
+			reply = newReply(req)
+		}
+
+		if reply.Error == "" {
+			reply.Text = fmt.Sprintf("app [%v] %sed", self.AppName, msg.Cmd)
+		}
+
+		self.Send(reply)
+	}()
 }
@@ -4,8 +4,15 @@ import { exists, remove } from "@ayonli/jsext/fs";
 import { absPath, timed } from "../util";
 import type { App } from "../app";
 
+/**
+ * The version of the control protocol spoken over the host socket, see `ProtocolVersion` in
+ * `pm/protocol.go`.
+ */
+export const PROTOCOL_VERSION = 2;
+
 export interface ControlMessage {
-    cmd: "handshake" | "goodbye" | "reply" | "stop" | "reload";
+    cmd: "handshake" | "ready" | "goodbye" | "reply" | "stop" | "reload" | "ping" | "pong"
+        | "drain" | "undrain";
     app?: string;
     msgId?: string;
     text?: string;
@@ -15,7 +22,13 @@ export interface ControlMessage {
         startTime: number;
     }[];
     error?: string;
+    // `code` tells the kind of the `error`, see `ErrorCode` in `pm/protocol.go`.
+    code?: "INVALID_MESSAGE" | "UNKNOWN_COMMAND" | "VERSION_MISMATCH" | "APP_NOT_FOUND"
+        | "APP_NOT_RUNNING" | "SPAWN_FAILED" | "UNHEALTHY" | "UNSUPPORTED"
+        | "TIMEOUT" | "COMMAND_FAILED";
 
+    // `version` is exchanged when `cmd` is `handshake`.
+    version?: number;
     // `pid` shall be provided when `cmd` is `handshake`.
     pid?: number;
 
@@ -73,15 +86,38 @@ export function getSocketPath() {
     return { sockFile, sockPath };
 }
 
+/** An error replied by the peer over the control protocol. */
+export class ProtocolError extends Error {
+    code: ControlMessage["code"];
+
+    constructor(code: ControlMessage["code"], message: string) {
+        super(message);
+        this.name = "ProtocolError";
+        this.code = code;
+    }
+}
+
+function isVersionMismatch(err: unknown): boolean {
+    return err instanceof ProtocolError && err.code === "VERSION_MISMATCH";
+}
+
 export class Guest {
     appName: string;
     appUrl: string;
     /** 0: disconnected; 1: connected; 2: closed */
     private state = 0;
+    /** The app has finished its initialization, see `ready()`. */
+    private isReady = false;
     private conn: net.Socket | undefined;
     private reconnector: NodeJS.Timeout | null = null;
+    private heartbeat: App["heartbeat"];
+    private heartbeater: NodeJS.Timeout | null = null;
+    /** The last time a message is received from the host server, see `startHeartbeat()`. */
+    private lastSeen = 0;
     private handleStopCommand: (msgId: string | undefined) => void;
     private handleReloadCommand: (msgId: string | undefined) => void;
+    /** The app instances that are drained, which shall not be selected by the load balancer. */
+    private drained = new Set<string>();
 
     constructor(app: App, options: {
         onStopCommand: (msgId: string | undefined) => void;
@@ -89,6 +125,7 @@ export class Guest {
     }) {
         this.appName = app.name;
         this.appUrl = app.url;
+        this.heartbeat = app.heartbeat;
         this.handleStopCommand = options?.onStopCommand;
         this.handleReloadCommand = options?.onReloadCommand;
     }
@@ -96,8 +133,12 @@ export class Guest {
     async join() {
         try {
             await this.connect();
-        } catch {
-            this.reconnect(); // auto-reconnect in the background
+        } catch (err) {
+            if (isVersionMismatch(err)) {
+                console.error(timed`${err}`);
+            } else {
+                this.reconnect(); // auto-reconnect in the background
+            }
         }
     }
 
@@ -108,15 +149,29 @@ export class Guest {
             throw new Error("host server is not running");
         }
 
-        await new Promise<void>(async (handshake, reject) => {
+        await new Promise<void>(async (resolve, reject) => {
             const connectFailureHandler = async (err: Error) => {
                 try { await remove(sockFile); } catch { }
                 reject(err);
             };
 
+            const handshake = (err?: Error) => {
+                if (err) {
+                    conn.destroy();
+                    reject(err);
+                } else {
+                    resolve();
+                }
+            };
+
             const conn = net.createConnection(sockPath, () => {
                 this.conn = conn;
-                this.send({ cmd: "handshake", app: this.appName, pid: process.pid });
+                this.send({
+                    cmd: "handshake",
+                    app: this.appName,
+                    pid: process.pid,
+                    version: PROTOCOL_VERSION,
+                });
                 conn.off("error", connectFailureHandler);
 
                 (async () => {
@@ -144,6 +199,26 @@ export class Guest {
         if (this.appName) {
             console.log(timed`app [${this.appName}] has joined the group`);
         }
+
+        this.lastSeen = Date.now();
+        this.startHeartbeat();
+
+        if (this.isReady) {
+            // Reconnected to the host server, restore the readiness.
+            this.send({ cmd: "ready", app: this.appName });
+        }
+    }
+
+    /**
+     * Notifies the host server that the app has finished its initialization and is ready to serve.
+     * If the guest is not connected yet, the notification is sent once it joins the group.
+     */
+    ready() {
+        this.isReady = true;
+
+        if (this.state === 1) {
+            this.send({ cmd: "ready", app: this.appName });
+        }
     }
 
     async leave(reason: string, replyId = ""): Promise<boolean> {
@@ -172,6 +247,11 @@ export class Guest {
             this.reconnector = null;
         }
 
+        if (this.heartbeater) {
+            clearInterval(this.heartbeater);
+            this.heartbeater = null;
+        }
+
         const ok = this.state == 1;
         this.state = 2;
         return ok;
@@ -194,11 +274,47 @@ export class Guest {
                         this.reconnector && clearInterval(this.reconnector);
                         this.reconnector = null;
                     }
-                } catch { }
+                } catch (err) {
+                    if (isVersionMismatch(err)) {
+                        console.error(timed`${err}`);
+                        this.reconnector && clearInterval(this.reconnector);
+                        this.reconnector = null;
+                    }
+                }
             }
         }, 1_000);
     }
 
+    /**
+     * Pings the host server periodically, once the host server doesn't respond for several
+     * heartbeats in a row, it's considered dead and the connection is destroyed, so the guest
+     * reconnects without waiting for a read error.
+     */
+    private startHeartbeat() {
+        const interval = this.heartbeat?.interval || 5_000;
+        const maxMisses = Math.max(this.heartbeat?.maxMisses || 0, 0) || 3;
+
+        if (interval < 0) {
+            return;
+        } else if (this.heartbeater) {
+            clearInterval(this.heartbeater);
+        }
+
+        this.heartbeater = setInterval(() => {
+            if (this.state !== 1) {
+                return; // reconnecting, the timer is restarted once connected
+            } else if (Date.now() - this.lastSeen > interval * maxMisses) {
+                console.error(timed`host server didn't respond within ${interval * maxMisses} ms, `
+                    + `reconnecting...`);
+                this.conn?.destroy();
+                this.handleHostDisconnection();
+            } else {
+                this.send({ cmd: "ping", msgId: Math.random().toString(36).slice(2, 10) });
+            }
+        }, interval);
+        this.heartbeater.unref();
+    }
+
     private handleHostDisconnection() {
         if (this.state === 0) {
             return;
@@ -208,26 +324,83 @@ export class Guest {
         }
     }
 
-    private processHostMessage(handshake: () => void, packet: string, buf: string) {
+    private processHostMessage(handshake: (err?: Error) => void, packet: string, buf: string) {
         const res = decodeMessage(packet, buf, false);
 
         for (const msg of res.messages) {
+            // Any message proves that the host server is alive.
+            this.lastSeen = Date.now();
             this.handleMessage(handshake, msg);
         }
 
         return packet;
     }
 
-    private handleMessage(handshake: () => void, msg: ControlMessage) {
+    private handleMessage(handshake: (err?: Error) => void, msg: ControlMessage) {
         if (msg.cmd === "handshake") {
-            this.state = 1;
-            handshake();
+            const version = msg.version || 1;
+
+            if (msg.error) {
+                handshake(new ProtocolError(msg.code, msg.error));
+            } else if (version !== PROTOCOL_VERSION) {
+                // The host server is of another version that doesn't check the version.
+                handshake(new ProtocolError("VERSION_MISMATCH", `protocol version mismatch `
+                    + `(host server: v${version}, client: v${PROTOCOL_VERSION}), make sure `
+                    + `they're of the same version of ngrpc, and restart the host server if ngrpc `
+                    + `has been upgraded`));
+            } else {
+                this.state = 1;
+                handshake();
+            }
         } else if (msg.cmd === "goodbye") {
             this.conn?.destroy();
         } else if (msg.cmd === "stop") {
             this.handleStopCommand(msg.msgId);
+        } else if (msg.cmd === "ping") {
+            // The host server probes the guest periodically to check if it's still responsive.
+            this.send({ cmd: "pong", msgId: msg.msgId });
+        } else if (msg.cmd === "pong") {
+            // The host server responds to the heartbeat, see `startHeartbeat()`.
         } else if (msg.cmd === "reload") {
             this.handleReloadCommand(msg.msgId);
+        } else if (msg.cmd === "drain" || msg.cmd === "undrain") {
+            this.handleDrain(msg);
+        } else if (msg.msgId && msg.cmd !== "reply") {
+            this.send({
+                cmd: "reply",
+                app: this.appName,
+                msgId: msg.msgId,
+                code: "UNKNOWN_COMMAND",
+                error: `unknown command '${msg.cmd}'`,
+            });
+        }
+    }
+
+    /**
+     * Reports whether the app instance has been drained, so it should be removed from the rotation
+     * of the load balancer.
+     */
+    isDrained(appName: string): boolean {
+        return this.drained.has(appName);
+    }
+
+    /**
+     * Handles the `drain` and `undrain` commands. The ones with a `msgId` target this app and are
+     * replied right away, since the in-flight calls are not tracked in Node.js, the others are
+     * broadcast by the host server to tell which instance is drained.
+     */
+    private handleDrain(msg: ControlMessage) {
+        if (msg.msgId) {
+            this.send({
+                cmd: "reply",
+                app: this.appName,
+                msgId: msg.msgId,
+                text: `app [${this.appName}] ${msg.cmd}ed`,
+            });
+        } else if (msg.cmd === "drain") {
+            this.drained.add(msg.app as string);
+        } else {
+            this.drained.delete(msg.app as string);
         }
     }
 }
@@ -131,7 +131,7 @@ func TestNewGuest(t *testing.T) {
 
 	assert.Equal(t, app.Name, guest.AppName)
 	assert.Equal(t, app.Url, guest.AppUrl)
-	assert.Equal(t, 0, guest.state)
+	assert.Equal(t, int32(0), guest.state.Load())
 	assert.NotNil(t, guest.handleStopCommand)
 }
 
@@ -155,14 +155,14 @@ func TestGuest_JoinAndLeave(t *testing.T) {
 	})
 	guest.Join()
 
-	assert.Equal(t, 1, guest.state)
-	assert.Equal(t, 1, len(host.clients))
+	assert.Equal(t, int32(1), guest.state.Load())
+	assert.Equal(t, 1, len(host.getClients()))
 
 	guest.Leave("app [example-server] stopped", "")
 
 	time.Sleep(time.Millisecond * 10) // wait a while for the host to close the connection
-	assert.Equal(t, 2, guest.state)
-	assert.Equal(t, 0, len(host.clients))
+	assert.Equal(t, int32(2), guest.state.Load())
+	assert.Equal(t, 0, len(host.getClients()))
 }
 
 func TestGuest_JoinRedundantSocketFile(t *testing.T) {
@@ -0,0 +1,216 @@
+package pm
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/config"
+)
+
+// How often the host server checks whether the heartbeats are due, it's a variable so the tests
+// can shorten it.
+var heartbeatTickInterval = time.Second
+
+const (
+	defaultHeartbeatInterval  = 5 * time.Second
+	defaultHeartbeatMaxMisses = 3
+)
+
+// heartbeatRecord keeps track of the heartbeats sent to a client.
+type heartbeatRecord struct {
+	lastPing time.Time
+	lastPong time.Time
+	// The `MsgId` of the last ping, its callback is dropped once the next ping is due.
+	pending string
+	// The number of heartbeats missed in a row.
+	misses       int
+	unresponsive bool
+}
+
+func getHeartbeatOptions(app config.App) (interval time.Duration, maxMisses int, restart bool) {
+	interval = defaultHeartbeatInterval
+	maxMisses = defaultHeartbeatMaxMisses
+
+	if options := app.Heartbeat; options != nil {
+		if options.Interval != 0 {
+			interval = time.Duration(options.Interval) * time.Millisecond
+		}
+
+		if options.MaxMisses > 0 {
+			maxMisses = options.MaxMisses
+		}
+
+		restart = options.Restart
+	}
+
+	return interval, maxMisses, restart
+}
+
+// monitorHeartbeats pings the guests periodically, unlike the health checks, the heartbeats are
+// sent to all the guests, and the host server does so in standalone mode as well.
+func (self *Host) monitorHeartbeats(tickInterval time.Duration) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if self.state.Load() != 1 {
+			break
+		}
+
+		self.checkHeartbeats(now)
+	}
+}
+
+func (self *Host) checkHeartbeats(now time.Time) {
+	clients := self.filterClients(func(item clientRecord) bool {
+		return item.App != "" && item.App != ":cli"
+	})
+
+	apps := self.getApps()
+
+	for _, client := range clients {
+		client := client // the ping callback below is called after the iteration
+		app, inConfig := slicex.Find(apps, func(item config.App, _ int) bool {
+			return item.Name == client.App
+		})
+
+		if !inConfig {
+			app = config.App{Name: client.App}
+		}
+
+		interval, maxMisses, restart := getHeartbeatOptions(app)
+
+		if interval < 0 {
+			continue
+		}
+
+		self.heartbeatsLock.Lock()
+		record, exists := self.heartbeats[client.conn]
+
+		if !exists {
+			// The first heartbeat is sent after an interval since the client is found.
+			record = &heartbeatRecord{lastPing: now, lastPong: now}
+			self.heartbeats[client.conn] = record
+		}
+
+		if now.Sub(record.lastPing) < interval {
+			self.heartbeatsLock.Unlock()
+			continue
+		}
+
+		if record.lastPong.Before(record.lastPing) {
+			record.misses++
+			self.callbacks.Delete(record.pending)
+		}
+
+		becameUnresponsive := record.misses >= maxMisses && !record.unresponsive
+
+		if becameUnresponsive {
+			record.unresponsive = true
+		}
+
+		record.lastPing = now
+		misses := record.misses
+		self.heartbeatsLock.Unlock()
+
+		if becameUnresponsive {
+			reason := fmt.Sprintf("is unresponsive after %d missed heartbeats", misses)
+			self.markClientUnresponsive(client.conn, true)
+			self.logApp(app, "app [%v] %s", app.Name, reason)
+			self.publishEvent(EventUnresponsive, app.Name, client.Pid, reason)
+
+			// In standalone mode, the host server can't respawn the app.
+			if restart && inConfig && !self.standalone {
+				go self.restartApp(client, reason, true)
+				continue
+			}
+		}
+
+		msgId := self.sendRequest(client, ControlMessage{Cmd: CmdPing}, func(reply ControlMessage) {
+			if reply.Err() == nil {
+				self.handleHeartbeat(app, client)
+			}
+		})
+
+		self.heartbeatsLock.Lock()
+		record.pending = msgId
+		self.heartbeatsLock.Unlock()
+	}
+}
+
+// handleHeartbeat records the pong of the client, and marks it as responsive again if it was not.
+func (self *Host) handleHeartbeat(app config.App, client clientRecord) {
+	self.heartbeatsLock.Lock()
+	record, ok := self.heartbeats[client.conn]
+
+	if !ok {
+		self.heartbeatsLock.Unlock()
+		return
+	}
+
+	record.lastPong = time.Now()
+	record.misses = 0
+	recovered := record.unresponsive
+	record.unresponsive = false
+	self.heartbeatsLock.Unlock()
+
+	if recovered {
+		self.markClientUnresponsive(client.conn, false)
+		self.logApp(app, "app [%v] is responsive again", app.Name)
+	}
+}
+
+func (self *Host) removeHeartbeat(conn net.Conn) {
+	self.heartbeatsLock.Lock()
+	delete(self.heartbeats, conn)
+	self.heartbeatsLock.Unlock()
+}
+
+// markClientUnresponsive marks the client of the connection as unresponsive (or not).
+func (self *Host) markClientUnresponsive(conn net.Conn, unresponsive bool) {
+	self.clientsLock.Lock()
+	defer self.clientsLock.Unlock()
+
+	for i := range self.clients {
+		if self.clients[i].conn == conn {
+			self.clients[i].Unresponsive = unresponsive
+		}
+	}
+}
+
+// heartbeat pings the host server periodically, once the host server doesn't respond for several
+// heartbeats in a row, it's considered dead and the connection is closed, so the guest reconnects
+// without waiting for a read error.
+func (self *Guest) heartbeat(conn net.Conn) {
+	if self.heartbeatInterval < 0 || self.AppName == ":cli" {
+		return
+	}
+
+	ticker := time.NewTicker(self.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-self.done:
+			return
+		case <-ticker.C:
+			if self.getConn() != conn {
+				return // reconnected
+			}
+
+			lastSeen := time.Unix(0, self.lastSeen.Load())
+			timeout := self.heartbeatInterval * time.Duration(self.heartbeatMaxMisses)
+
+			if time.Since(lastSeen) > timeout {
+				log.Printf("host server didn't respond within %v, reconnecting...", timeout)
+				conn.Close() // the disconnection is handled by the reading goroutine
+				return
+			}
+
+			self.Send(ControlMessage{Cmd: CmdPing, MsgId: newMsgId()})
+		}
+	}
+}
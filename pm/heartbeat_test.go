@@ -0,0 +1,260 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/pm/socket"
+	"github.com/stretchr/testify/assert"
+)
+
+// joinSilently joins the host server as the app and only responds to the pings when `responsive`
+// is set, it returns the connection.
+func joinSilently(appName string, pid int, responsive *atomic.Bool) net.Conn {
+	_, sockPath := GetSocketPath()
+	conn := goext.Ok(socket.DialTimeout(sockPath, time.Second))
+	conn.Write(EncodeMessage(ControlMessage{
+		Cmd:     CmdHandshake,
+		App:     appName,
+		Pid:     pid,
+		Version: ProtocolVersion,
+	}))
+	conn.Write(EncodeMessage(ControlMessage{Cmd: CmdReady, App: appName}))
+
+	go func() {
+		packet := []byte{}
+		buf := make([]byte, 256)
+
+		for {
+			n, err := conn.Read(buf)
+
+			for _, msg := range DecodeMessage(&packet, buf[:n], err != nil) {
+				if msg.Cmd == CmdPing && responsive.Load() {
+					conn.Write(EncodeMessage(ControlMessage{Cmd: CmdPong, MsgId: msg.MsgId}))
+				}
+			}
+
+			if err != nil {
+				break
+			}
+		}
+	}()
+
+	return conn
+}
+
+func waitClientState(t *testing.T, host *Host, appName string, unresponsive bool) {
+	deadline := time.Now().Add(time.Second * 2)
+
+	for time.Now().Before(deadline) {
+		client, ok := host.findClient(func(item clientRecord) bool {
+			return item.App == appName
+		})
+
+		if ok && client.Unresponsive == unresponsive {
+			return
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Fatalf("app [%s] is not marked as unresponsive=%v", appName, unresponsive)
+}
+
+func TestHost_heartbeats(t *testing.T) {
+	conf := `{"apps":[{"name":"hb-app","url":"grpc://localhost:4014","stdout":"hb.log",` +
+		`"heartbeat":{"interval":50,"maxMisses":2}}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("hb.log")
+
+	interval := heartbeatTickInterval
+	heartbeatTickInterval = time.Millisecond * 10
+	defer func() {
+		heartbeatTickInterval = interval
+	}()
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	events := make(chan AppEvent, 10)
+	stream := goext.Ok(SubscribeEvents(func(event AppEvent) { events <- event }))
+	defer stream.Close()
+
+	responsive := &atomic.Bool{}
+	conn := joinSilently("hb-app", 12345, responsive)
+	defer conn.Close()
+	assert.Equal(t, EventOnline, waitEvent(t, events).Type)
+
+	waitClientState(t, host, "hb-app", true)
+	event := waitEvent(t, events)
+	assert.Equal(t, EventUnresponsive, event.Type)
+	assert.Equal(t, 12345, event.Pid)
+	assert.Equal(t, "is unresponsive after 2 missed heartbeats", event.Reason)
+
+	// The app is not killed without the `restart` option.
+	responsive.Store(true)
+	waitClientState(t, host, "hb-app", false)
+
+	log := string(goext.Ok(os.ReadFile("hb.log")))
+	assert.Contains(t, log, "app [hb-app] is unresponsive after 2 missed heartbeats\n")
+	assert.Contains(t, log, "app [hb-app] is responsive again\n")
+
+	// The callbacks of the missed pings are dropped.
+	time.Sleep(time.Millisecond * 100)
+	assert.LessOrEqual(t, len(host.callbacks.Keys()), 1)
+}
+
+func TestHost_heartbeatsMultipleGuests(t *testing.T) {
+	conf := `{"apps":[` +
+		`{"name":"hb-app-a","url":"grpc://localhost:4016","heartbeat":{"interval":50,"maxMisses":2}},` +
+		`{"name":"hb-app-b","url":"grpc://localhost:4017","heartbeat":{"interval":50,"maxMisses":2}}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	defer os.Remove("ngrpc.json")
+
+	interval := heartbeatTickInterval
+	heartbeatTickInterval = time.Millisecond * 10
+	defer func() {
+		heartbeatTickInterval = interval
+	}()
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	responsive := &atomic.Bool{}
+	responsive.Store(true)
+	connA := joinSilently("hb-app-a", 12345, responsive)
+	defer connA.Close()
+	connB := joinSilently("hb-app-b", 12346, responsive)
+	defer connB.Close()
+
+	// Each pong is credited to the guest that sent it, so none of them misses the heartbeats.
+	time.Sleep(time.Millisecond * 300)
+
+	for _, appName := range []string{"hb-app-a", "hb-app-b"} {
+		client, ok := host.findClient(func(item clientRecord) bool {
+			return item.App == appName
+		})
+		assert.True(t, ok)
+		assert.False(t, client.Unresponsive, appName)
+	}
+}
+
+func TestHost_heartbeatsRestart(t *testing.T) {
+	conf := `{"apps":[{"name":"hung-app","url":"grpc://localhost:4015","entry":"sleep.sh",` +
+		`"heartbeat":{"interval":50,"maxMisses":2,"restart":true}}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	goext.Ok(0, os.WriteFile("sleep.sh", []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("sleep.sh")
+
+	interval := heartbeatTickInterval
+	heartbeatTickInterval = time.Millisecond * 10
+	defer func() {
+		heartbeatTickInterval = interval
+	}()
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	events := make(chan AppEvent, 10)
+	stream := goext.Ok(SubscribeEvents(func(event AppEvent) { events <- event }))
+	defer stream.Close()
+
+	// The process joins the group, then hangs.
+	pid := goext.Ok(host.spawnApp(host.apps[0]))
+	conn := joinSilently("hung-app", pid, &atomic.Bool{})
+	defer conn.Close()
+
+	assert.Equal(t, EventOnline, waitEvent(t, events).Type)
+	assert.Equal(t, EventUnresponsive, waitEvent(t, events).Type)
+
+	event := waitEvent(t, events)
+	assert.Equal(t, EventOffline, event.Type)
+	assert.Equal(t, pid, event.Pid)
+	assert.Equal(t, "killed", event.Reason)
+
+	event = waitEvent(t, events)
+	assert.Equal(t, EventRestarted, event.Type)
+	assert.NotEqual(t, pid, event.Pid)
+	assert.Equal(t, "is unresponsive after 2 missed heartbeats", event.Reason)
+
+	host.markStopping("hung-app")
+	killProcess(event.Pid)
+	time.Sleep(time.Millisecond * 100) // wait for the exit to be handled
+}
+
+func TestGuest_heartbeat(t *testing.T) {
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(`{"apps":[]}`), 0644))
+	defer os.Remove("ngrpc.json")
+
+	// A host server that accepts the guests but never responds after the handshake.
+	sockFile, sockPath := GetSocketPath()
+	listener := goext.Ok(socket.Listen(sockPath))
+	defer os.Remove(sockFile)
+	defer listener.Close()
+	handshakes := make(chan time.Time, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				break
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				packet := []byte{}
+				buf := make([]byte, 256)
+
+				for {
+					n, err := conn.Read(buf)
+
+					for _, msg := range DecodeMessage(&packet, buf[:n], err != nil) {
+						if msg.Cmd == CmdHandshake {
+							conn.Write(EncodeMessage(ControlMessage{
+								Cmd:     CmdHandshake,
+								Version: ProtocolVersion,
+							}))
+							handshakes <- time.Now()
+						}
+					}
+
+					if err != nil {
+						break
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	guest := NewGuest(config.App{
+		Name:      "hb-guest",
+		Heartbeat: &config.HeartbeatOptions{Interval: 50, MaxMisses: 2},
+	}, func(msgId string) {})
+	guest.Join()
+	defer guest.Leave("", "")
+
+	joinedAt := <-handshakes
+
+	// The guest gives up the connection after 2 missed heartbeats and reconnects a second later.
+	select {
+	case reconnectedAt := <-handshakes:
+		assert.GreaterOrEqual(t, reconnectedAt.Sub(joinedAt), time.Millisecond*100)
+		assert.Less(t, reconnectedAt.Sub(joinedAt), time.Millisecond*1500)
+	case <-time.After(time.Second * 3):
+		t.Fatal("the guest didn't reconnect")
+	}
+}
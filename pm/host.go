@@ -10,8 +10,10 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -35,20 +37,54 @@ func init() {
 var openForAppend = os.O_CREATE | os.O_APPEND | os.O_WRONLY
 var defaultTsOutDir = "node_modules/.ngrpc"
 
+const (
+	defaultStartTimeout  = 30 * time.Second
+	defaultStopTimeout   = 10 * time.Second
+	defaultDrainTimeout  = 30 * time.Second
+	numLogLinesOnFailure = 20
+)
+
 type appStat struct {
-	app    string
-	url    string
-	pid    int
-	uptime int
-	memory float64
-	cpu    float64
+	app       string
+	url       string
+	pid       int
+	uptime    int
+	memory    float64
+	cpu       float64
+	ready     bool
+	unhealthy bool
+	// The app has missed several heartbeats in a row.
+	unresponsive bool
+	drained      bool
+	errored      bool
+	restarts     int
+	lastCrash    int
 }
 
 type clientRecord struct {
 	conn      net.Conn
 	App       string `json:"app"`
+	Url       string `json:"url"`
 	Pid       int    `json:"pid"`
 	StartTime int    `json:"startTime"`
+	// The app has finished its initialization and sent the `ready` command.
+	Ready bool `json:"ready"`
+	// The app has failed the health checks and is being restarted.
+	Unhealthy bool `json:"unhealthy"`
+	// The app has missed several heartbeats in a row, see `checkHeartbeats()`.
+	Unresponsive bool `json:"unresponsive"`
+	// The app has been removed from the rotation by the `drain` command.
+	Drained bool `json:"drained"`
+	// The env that the app is spawned with, only reported by the `list` command for the apps that
+	// are supervised by the host server.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+type watcherRecord struct {
+	conn net.Conn
+	app  string
+	// The `MsgId` of the `watch` command, which the `members` are correlated by.
+	msgId string
 }
 
 type clientReading struct {
@@ -63,32 +99,78 @@ type messageRecord struct {
 	msg  ControlMessage
 }
 
+// callbackRecord waits for the reply of a command sent to a client, see `sendRequest()`.
+type callbackRecord struct {
+	conn net.Conn
+	app  string
+	fn   func(reply ControlMessage)
+}
+
+// CommandOptions holds the optional flags of the commands sent by the CLI.
+type CommandOptions struct {
+	// Rebuild the Go entries even if their sources haven't changed, used by `start` and `restart`.
+	Rebuild bool
+	// Restart the apps one by one and wait for each of them to be online and healthy before moving
+	// on, used by `restart`.
+	Rolling bool
+	// Stop the apps after they're drained, used by `drain`.
+	Stop bool
+	// How long to wait for the processes of the apps to exit after being asked to stop, used by
+	// `stop`, or for the in-flight calls to finish, used by `drain`.
+	Timeout time.Duration
+	// Send SIGTERM, then SIGKILL to the processes that don't exit in time, used by `stop`.
+	Force bool
+}
+
 // The Host-Guest model is a mechanism used to hold communication between all apps running on
 // the same machine.
 //
 // This mechanism is primarily used for the CLI tool sending control commands to the apps.
 type Host struct {
-	apps       []config.App
-	tsCfg      config.TsConfig
-	sockFile   string
-	state      int
-	standalone bool
-	server     net.Listener
-	clients    []clientRecord
-	callbacks  *collections.Map[string, func(reply ControlMessage)]
-
-	isProcessKeeper bool
+	apps     []config.App
+	tsCfg    config.TsConfig
+	sockFile string
+	// 0: stopped; 1: running
+	state       atomic.Int32
+	standalone  bool
+	server      net.Listener
+	clients     []clientRecord
+	watchers    []watcherRecord
+	subscribers []subscriberRecord
+	callbacks   *collections.Map[string, callbackRecord]
+	restarts    map[string]*restartRecord
+	processes   map[string]*processRecord
+	monitors    map[string]*appMonitor
+	heartbeats  map[net.Conn]*heartbeatRecord
+	// The binaries of the Go entries, keyed by the entries, see `resolveGoBinary()`.
+	binaries *collections.Map[string, string]
+	options  CommandOptions
+
+	isProcessKeeper atomic.Bool
+	stopOnce        sync.Once
 	clientsLock     sync.RWMutex
+	watchersLock    sync.Mutex
+	subscribersLock sync.Mutex
+	restartsLock    sync.Mutex
+	processesLock   sync.Mutex
+	monitorsLock    sync.Mutex
+	heartbeatsLock  sync.Mutex
 }
 
 func NewHost(conf config.Config, standalone bool) *Host {
 	host := &Host{
 		apps:        conf.Apps,
-		state:       0,
 		standalone:  standalone,
 		server:      nil,
 		clients:     []clientRecord{},
-		callbacks:   &collections.Map[string, func(reply ControlMessage)]{},
+		watchers:    []watcherRecord{},
+		subscribers: []subscriberRecord{},
+		callbacks:   &collections.Map[string, callbackRecord]{},
+		restarts:    map[string]*restartRecord{},
+		processes:   map[string]*processRecord{},
+		monitors:    map[string]*appMonitor{},
+		heartbeats:  map[net.Conn]*heartbeatRecord{},
+		binaries:    &collections.Map[string, string]{},
 		clientsLock: sync.RWMutex{},
 	}
 
@@ -109,7 +191,7 @@ func (self *Host) Start(wait bool) error {
 		return err
 	}
 
-	self.state = 1
+	self.state.Store(1)
 	self.server = listener
 	self.sockFile = sockFile
 
@@ -118,12 +200,12 @@ func (self *Host) Start(wait bool) error {
 			conn, err := listener.Accept()
 
 			if err != nil {
-				if self.state == 0 { // server has shut down
+				if self.state.Load() == 0 { // server has shut down
 					break
 				} else {
 					continue
 				}
-			} else if self.state == 0 {
+			} else if self.state.Load() == 0 {
 				break
 			}
 
@@ -131,24 +213,50 @@ func (self *Host) Start(wait bool) error {
 		}
 	}()
 
+	if !self.standalone {
+		// In standalone mode, the host server doesn't spawn apps, so it can't restart them either.
+		go self.monitorApps()
+	}
+
+	go self.monitorHeartbeats(heartbeatTickInterval)
+
 	if wait {
-		self.waitForExit()
+		self.WaitForExit()
 	}
 
 	return nil
 }
 
 func (self *Host) Stop() {
-	self.state = 0
+	// The host may be stopped by the `stop-host` command and the program at the same time, the
+	// latter call waits for the former one to finish, so the socket file will not be removed after
+	// the call returns, when it may have been taken by a new host server.
+	self.stopOnce.Do(self.stop)
+}
 
-	if len(self.clients) > 0 { // the :cli client may still be online
-		for _, client := range self.clients {
-			client.conn.Write(EncodeMessage(ControlMessage{Cmd: "goodbye", Fin: true}))
-		}
+func (self *Host) stop() {
+	self.state.Store(0)
+	clients := self.getClients()
+
+	// The :cli client may still be online.
+	for _, client := range clients {
+		client.conn.Write(EncodeMessage(ControlMessage{Cmd: CmdGoodbye, Fin: true}))
+	}
+
+	self.watchersLock.Lock()
+	for _, watcher := range self.watchers {
+		watcher.conn.Write(EncodeMessage(ControlMessage{Cmd: CmdGoodbye, Fin: true}))
 	}
+	self.watchersLock.Unlock()
+
+	self.subscribersLock.Lock()
+	for _, subscriber := range self.subscribers {
+		subscriber.conn.Write(EncodeMessage(ControlMessage{Cmd: CmdGoodbye, Fin: true}))
+	}
+	self.subscribersLock.Unlock()
 
 	if self.server != nil {
-		if len(self.clients) > 0 {
+		if len(clients) > 0 {
 			time.Sleep(time.Millisecond * 10) // wait a while for the message to be flushed
 		}
 
@@ -157,13 +265,15 @@ func (self *Host) Stop() {
 
 	os.Remove(self.sockFile)
 
-	if self.isProcessKeeper {
+	if self.isProcessKeeper.Load() {
 		os.Exit(0)
 	}
 }
 
-func (self *Host) waitForExit() {
-	self.isProcessKeeper = true
+// WaitForExit blocks until the host server receives the interrupt signal from the system, then
+// stops the host server.
+func (self *Host) WaitForExit() {
+	self.isProcessKeeper.Store(true)
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
@@ -177,6 +287,13 @@ func (self *Host) addClient(client clientRecord) {
 	self.clientsLock.Unlock()
 }
 
+// getClients returns a snapshot of the clients.
+func (self *Host) getClients() []clientRecord {
+	self.clientsLock.RLock()
+	defer self.clientsLock.RUnlock()
+	return slices.Clone(self.clients)
+}
+
 func (self *Host) findClient(test func(client clientRecord) bool) (clientRecord, bool) {
 	self.clientsLock.RLock()
 	client, ok := slicex.Find(self.clients, func(item clientRecord, idx int) bool {
@@ -195,6 +312,69 @@ func (self *Host) filterClients(test func(client clientRecord) bool) []clientRec
 	return clients
 }
 
+// markClientReady marks the client of the connection as ready and returns the updated record.
+func (self *Host) markClientReady(conn net.Conn) (clientRecord, bool) {
+	self.clientsLock.Lock()
+	defer self.clientsLock.Unlock()
+
+	for i := range self.clients {
+		if self.clients[i].conn == conn {
+			self.clients[i].Ready = true
+			return self.clients[i], true
+		}
+	}
+
+	return clientRecord{}, false
+}
+
+// markClientsDrained marks the clients as drained (or not), and broadcasts the change to the
+// watchers and the guests, so they stop (or resume) routing traffic to the instances.
+func (self *Host) markClientsDrained(clients []clientRecord, drained bool) {
+	self.clientsLock.Lock()
+	for i := range self.clients {
+		if slices.ContainsFunc(clients, func(item clientRecord) bool {
+			return item.conn == self.clients[i].conn
+		}) {
+			self.clients[i].Drained = drained
+		}
+	}
+	self.clientsLock.Unlock()
+
+	for _, client := range clients {
+		self.notifyWatchers(client.App)
+		self.broadcastDrain(client.App, drained)
+	}
+}
+
+// broadcastDrain tells all the guests that the app instance is drained or undrained.
+func (self *Host) broadcastDrain(appName string, drained bool) {
+	msg := ControlMessage{Cmd: CmdDrain, App: appName}
+
+	if !drained {
+		msg.Cmd = CmdUndrain
+	}
+
+	guests := self.filterClients(func(item clientRecord) bool {
+		return item.App != "" && item.App != ":cli"
+	})
+
+	for _, guest := range guests {
+		guest.conn.Write(EncodeMessage(msg))
+	}
+}
+
+// markClientUnhealthy marks the client of the connection as unhealthy.
+func (self *Host) markClientUnhealthy(conn net.Conn) {
+	self.clientsLock.Lock()
+	defer self.clientsLock.Unlock()
+
+	for i := range self.clients {
+		if self.clients[i].conn == conn {
+			self.clients[i].Unhealthy = true
+		}
+	}
+}
+
 func (self *Host) removeClient(test func(client clientRecord) bool) bool {
 	self.clientsLock.Lock()
 	count := len(self.clients)
@@ -206,6 +386,53 @@ func (self *Host) removeClient(test func(client clientRecord) bool) bool {
 	return ok
 }
 
+func (self *Host) addWatcher(watcher watcherRecord) {
+	self.watchersLock.Lock()
+	self.watchers = append(self.watchers, watcher)
+	self.watchersLock.Unlock()
+
+	self.notifyWatcher(watcher)
+}
+
+func (self *Host) removeWatcher(conn net.Conn) {
+	self.watchersLock.Lock()
+	self.watchers = slicex.Filter(self.watchers, func(item watcherRecord, idx int) bool {
+		return item.conn != conn
+	})
+	self.watchersLock.Unlock()
+}
+
+// notifyWatchers sends the membership of the app to the watchers that are watching it.
+func (self *Host) notifyWatchers(appName string) {
+	if appName == "" || appName == ":cli" {
+		return
+	}
+
+	self.watchersLock.Lock()
+	watchers := slicex.Filter(self.watchers, func(item watcherRecord, idx int) bool {
+		return config.MatchApp(appName, item.app)
+	})
+	self.watchersLock.Unlock()
+
+	for _, watcher := range watchers {
+		self.notifyWatcher(watcher)
+	}
+}
+
+func (self *Host) notifyWatcher(watcher watcherRecord) {
+	clients := self.filterClients(func(item clientRecord) bool {
+		// Only the instances that are ready to serve are reported to the watchers.
+		return item.Ready && !item.Drained && item.Url != "" &&
+			config.MatchApp(item.App, watcher.app)
+	})
+	watcher.conn.Write(EncodeMessage(ControlMessage{
+		Cmd:    CmdMembers,
+		App:    watcher.app,
+		MsgId:  watcher.msgId,
+		Guests: clients,
+	}))
+}
+
 func (self *Host) handleGuestConnection(conn net.Conn) {
 	packet := []byte{}
 	buf := make([]byte, 256)
@@ -228,6 +455,11 @@ func (self *Host) handleGuestConnection(conn net.Conn) {
 }
 
 func (self *Host) handleGuestDisconnection(conn net.Conn) {
+	self.removeWatcher(conn)
+	self.removeSubscriber(conn)
+	self.removeHeartbeat(conn)
+	self.cancelCallbacks(conn)
+
 	client, exists := self.findClient(func(item clientRecord) bool {
 		return item.conn == conn
 	})
@@ -235,42 +467,109 @@ func (self *Host) handleGuestDisconnection(conn net.Conn) {
 	if !exists {
 		return
 	} else {
-		self.clients = slicex.Filter[[]clientRecord](
-			self.clients,
-			func(item clientRecord, idx int) bool {
-				return item.conn != conn
-			},
-		)
+		self.removeClient(func(item clientRecord) bool {
+			return item.conn == conn
+		})
+		self.notifyWatchers(client.App)
+
+		if client.Drained {
+			self.broadcastDrain(client.App, false)
+		}
 	}
 
-	if client.App != "" && client.App != ":cli" && self.state == 1 && !self.standalone {
-		// When the guest app is closed expectedly, it sends a `goodbye` command to the
-		// host server and the server removes it normally. Otherwise, the connection is
-		// closed due to program failure on the guest app, we can try to revive it.
+	if client.App == "" || client.App == ":cli" || self.isSupervised(client.App) {
+		// If the app is spawned by the host, its process exit is used to decide whether it crashed
+		// and whether to revive it instead.
+		return
+	}
 
-		app, exists := slicex.Find(self.apps, func(item config.App, idx int) bool {
-			return item.Name == client.App
-		})
+	// When the guest app is closed expectedly, it sends a `goodbye` command to the host server and
+	// the server removes it normally. Otherwise, the connection is closed due to program failure on
+	// the guest app, we can try to revive it.
+	self.publishEvent(EventCrashed, client.App, client.Pid, "exited accidentally")
+
+	if self.state.Load() == 1 && !self.standalone {
+		app, exists := self.findAppConfig(client.App)
 
 		if exists {
-			time.Sleep(time.Second)
+			// The guest connection is closed without a `goodbye`, which is considered a failure.
+			self.reviveApp(app, true, "exited accidentally")
+		}
+	}
+}
 
-			if app.Stdout != "" {
-				// Write the log to the app's log file instead, because the host daemon does not
-				// have its own logger.
-				file, err := os.OpenFile(app.Stdout, openForAppend, 0644)
+// logApp writes the log to the app's log file, because the host daemon does not have its own
+// logger.
+func (self *Host) logApp(app config.App, format string, args ...any) {
+	if app.Stdout == "" {
+		return
+	}
 
-				if err == nil {
-					logger := log.New(file, "", log.LstdFlags)
-					logger.Printf("app [%v] exited accidentally, reviving...", client.App)
-				}
+	writer, err := NewLogWriter(app.Name, app.Stdout, app.Log)
 
-				file.Close()
-			}
+	if err == nil {
+		flags := log.LstdFlags
 
-			SpawnApp(app, self.tsCfg)
+		if app.Log != nil && app.Log.Timestamp {
+			flags = 0 // the writer prepends the timestamp
 		}
+
+		log.New(writer, "", flags).Printf(format, args...)
+		writer.Close()
+	}
+}
+
+func (self *Host) recordCrash(app config.App, failed bool) (bool, time.Duration) {
+	self.restartsLock.Lock()
+	defer self.restartsLock.Unlock()
+
+	record, ok := self.restarts[app.Name]
+
+	if !ok {
+		record = &restartRecord{App: app.Name}
+		self.restarts[app.Name] = record
+	}
+
+	return record.recordCrash(app, failed, time.Now())
+}
+
+func (self *Host) recordExit(appName string, code int, signal string) {
+	self.restartsLock.Lock()
+	defer self.restartsLock.Unlock()
+
+	record, ok := self.restarts[appName]
+
+	if !ok {
+		record = &restartRecord{App: appName}
+		self.restarts[appName] = record
+	}
+
+	record.ExitCode = code
+	record.Signal = signal
+}
+
+func (self *Host) getRestartRecord(appName string) restartRecord {
+	self.restartsLock.Lock()
+	defer self.restartsLock.Unlock()
+
+	if record, ok := self.restarts[appName]; ok {
+		return *record
+	} else {
+		return restartRecord{App: appName}
+	}
+}
+
+func (self *Host) listRestartRecords() []restartRecord {
+	self.restartsLock.Lock()
+	defer self.restartsLock.Unlock()
+
+	records := []restartRecord{}
+
+	for _, record := range self.restarts {
+		records = append(records, *record)
 	}
+
+	return records
 }
 
 func (self *Host) processGuestMessage(
@@ -279,99 +578,298 @@ func (self *Host) processGuestMessage(
 	bufRead []byte,
 	eof bool,
 ) {
-	for _, msg := range DecodeMessage(packet, bufRead, eof) {
+	messages, errs := decodeMessages(packet, bufRead, eof)
+
+	for _, err := range errs {
+		conn.Write(EncodeMessage(newErrorReply(ControlMessage{}, err.(*ProtocolError))))
+	}
+
+	for _, msg := range messages {
 		self.handleMessage(conn, msg)
 	}
 }
 
 func (self *Host) handleMessage(conn net.Conn, msg ControlMessage) {
-	if msg.Cmd == "handshake" {
+	if msg.Cmd == CmdHandshake {
 		self.handleHandshake(conn, msg)
-	} else if msg.Cmd == "goodbye" {
+	} else if msg.Cmd == CmdReady {
+		self.handleReady(conn, msg)
+	} else if msg.Cmd == CmdGoodbye {
 		self.handleGoodbye(conn, msg)
-	} else if msg.Cmd == "reply" {
+	} else if msg.Cmd == CmdReply || msg.Cmd == CmdPong {
 		self.handleReply(conn, msg)
-	} else if msg.Cmd == "stop" || msg.Cmd == "reload" {
+	} else if msg.Cmd == CmdPing {
+		// The guest checks whether the host server is still responsive.
+		conn.Write(EncodeMessage(ControlMessage{Cmd: CmdPong, MsgId: msg.MsgId}))
+	} else if msg.Cmd == CmdStop || msg.Cmd == CmdReload ||
+		msg.Cmd == CmdDrain || msg.Cmd == CmdUndrain {
 		// When the host server receives a control command, it distribute the command to the target
 		// app or all apps if the app is not specified.
 
-		if msg.App != "" {
-			client, exists := self.findClient(func(item clientRecord) bool {
-				return item.App == msg.App && item.App != ":cli"
-			})
-
-			if exists {
-				msgId := stringx.Random(8)
-
-				client.conn.Write(EncodeMessage(ControlMessage{Cmd: msg.Cmd, MsgId: msgId}))
-				self.callbacks.Set(msgId, func(reply ControlMessage) {
-					reply.Fin = true
-					conn.Write(EncodeMessage(reply))
-				})
+		clients := self.filterClients(func(item clientRecord) bool {
+			if msg.App != "" {
+				// The app name can be either the base name or a specific replica.
+				return item.App != ":cli" && config.MatchApp(item.App, msg.App)
 			} else {
-				conn.Write(EncodeMessage(ControlMessage{
-					Cmd:   "reply",
-					Error: fmt.Sprintf("app [%s] is not running", msg.App),
-					Fin:   true,
-				}))
-			}
-		} else {
-			clients := self.filterClients(func(item clientRecord) bool {
 				return item.App != ":cli"
-			})
+			}
+		})
 
-			if len(clients) > 0 {
-				count := 0
-				lock := sync.Mutex{}
+		if len(clients) > 0 {
+			waves := [][]clientRecord{clients}
+
+			if msg.Cmd == CmdStop {
+				// Stop the apps in the reverse order of their dependencies, so an app is always
+				// stopped before the apps it depends on.
+				waves = self.groupClients(clients)
+				slices.Reverse(waves)
+			} else if msg.Cmd == CmdDrain || msg.Cmd == CmdUndrain {
+				// Take the instances out of (or back into) the rotation before the apps are
+				// notified, so no new traffic is routed to them while they're draining.
+				self.markClientsDrained(clients, msg.Cmd == CmdDrain)
+			}
 
-				slicex.ForEach(clients, func(client clientRecord, _ int) {
-					msgId := stringx.Random(8)
+			// The replies are handled in this goroutine, so the waves must run in another one.
+			go self.dispatchCommand(conn, msg, waves)
+		} else {
+			var reply ControlMessage
 
-					client.conn.Write(EncodeMessage(ControlMessage{Cmd: msg.Cmd, MsgId: msgId}))
-					self.callbacks.Set(msgId, func(reply ControlMessage) {
-						lock.Lock()
-						count++
-						reply.Fin = count == len(clients)
-						conn.Write(EncodeMessage(reply))
-						lock.Unlock()
-					})
-				})
+			if msg.App != "" {
+				reply = newErrorReply(msg, newProtocolError(ErrAppNotRunning,
+					"app [%s] is not running", msg.App))
 			} else { // this block is very unlikely to be hit, though
-				conn.Write(EncodeMessage(ControlMessage{
-					Cmd:   "reply",
-					Error: "no app is running",
-					Fin:   true,
-				}))
+				reply = newErrorReply(msg, newProtocolError(ErrAppNotRunning, "no app is running"))
 			}
+
+			reply.Fin = true
+			conn.Write(EncodeMessage(reply))
 		}
-	} else if msg.Cmd == "list" {
+	} else if msg.Cmd == CmdList {
 		clients := self.filterClients(func(item clientRecord) bool {
 			return item.App != "" && item.App != ":cli"
 		})
-		conn.Write(EncodeMessage(ControlMessage{
-			Cmd:    "reply",
-			Guests: clients,
-			Fin:    true,
-		}))
-	} else if msg.Cmd == "stop-host" {
+		reply := newReply(msg)
+		reply.Guests = slicex.Map(clients, func(item clientRecord, _ int) clientRecord {
+			item.Env = self.getSpawnedEnv(item)
+			return item
+		})
+		reply.Restarts = self.listRestartRecords()
+		reply.Fin = true
+		conn.Write(EncodeMessage(reply))
+	} else if msg.Cmd == CmdSpawn {
+		app, exists := self.findAppConfig(msg.App)
+		reply := newReply(msg)
+
+		if exists && msg.Env != nil {
+			app.Env = msg.Env
+		}
+
+		if exists && msg.Binary != "" && filepath.Ext(app.Entry) == ".go" {
+			// The CLI has just built the entry, the revives and restarts use the new binary as well.
+			self.binaries.Set(app.Entry, msg.Binary)
+		}
+
+		if !exists {
+			reply = newErrorReply(msg, newProtocolError(ErrAppNotFound,
+				"app [%s] doesn't exist in the config file", msg.App))
+		} else if pid, err := self.spawnApp(app); err != nil {
+			reply = newErrorReply(msg, newProtocolError(ErrSpawnFailed, "%v", err))
+		} else {
+			reply.Pid = pid
+		}
+
+		conn.Write(EncodeMessage(reply))
+	} else if msg.Cmd == CmdHealth {
+		// The health check may take a while, don't block the messages of the connection.
+		go self.handleHealth(conn, msg)
+	} else if msg.Cmd == CmdWatch {
+		if self.checkVersion(conn, msg) {
+			self.addWatcher(watcherRecord{conn: conn, app: msg.App, msgId: msg.MsgId})
+		}
+	} else if msg.Cmd == CmdSubscribe {
+		if self.checkVersion(conn, msg) {
+			self.addSubscriber(subscriberRecord{conn: conn, msgId: msg.MsgId})
+			conn.Write(EncodeMessage(newReply(msg)))
+		}
+	} else if msg.Cmd == CmdResolve {
+		if !self.checkVersion(conn, msg) {
+			return
+		}
+
+		client, exists := self.findClient(func(item clientRecord) bool {
+			return item.App == msg.App && item.Url != ""
+		})
+		reply := newReply(msg)
+
+		if exists {
+			reply.Url = client.Url
+		} else {
+			reply = newErrorReply(msg, newProtocolError(ErrAppNotRunning,
+				"app [%s] is not running", msg.App))
+		}
+
+		reply.Fin = true
+		conn.Write(EncodeMessage(reply))
+	} else if msg.Cmd == CmdStopHost {
+		// Acknowledge the sender before the host server says goodbye.
+		conn.Write(EncodeMessage(newReply(msg)))
 		self.Stop()
 	} else {
-		conn.Write(EncodeMessage(ControlMessage{
-			Cmd:   "reply",
-			Error: "invalid message",
-			Fin:   true,
-		}))
+		reply := newErrorReply(msg, newProtocolError(ErrUnknownCommand,
+			"unknown command '%s'", msg.Cmd))
+		reply.Fin = true
+		conn.Write(EncodeMessage(reply))
+	}
+}
+
+// checkVersion checks the protocol version of the message that starts a session, the sender is
+// rejected if it speaks another version.
+func (self *Host) checkVersion(conn net.Conn, msg ControlMessage) bool {
+	if version := getVersion(msg); version != ProtocolVersion {
+		reply := newErrorReply(msg, newVersionMismatchError(ProtocolVersion, version))
+		reply.Version = ProtocolVersion
+		reply.Fin = true
+
+		if msg.Cmd == CmdHandshake {
+			reply.Cmd = CmdHandshake
+		}
+
+		conn.Write(EncodeMessage(reply))
+		log.Printf("%s rejected: %s", msg.Cmd, reply.Error)
+		return false
+	}
+
+	return true
+}
+
+// dispatchCommand sends the command to the clients wave by wave, the next wave starts after all the
+// clients in the previous one have replied, and the replies are forwarded to the sender `conn`,
+// correlated by the `MsgId` of the request.
+func (self *Host) dispatchCommand(conn net.Conn, req ControlMessage, waves [][]clientRecord) {
+	total := 0
+	forwarded := 0
+
+	for _, wave := range waves {
+		total += len(wave)
+	}
+
+	for _, wave := range waves {
+		// The callbacks only pass the replies over, which are counted and forwarded by this
+		// goroutine alone.
+		replies := make(chan ControlMessage, len(wave))
+
+		for _, client := range wave {
+			if req.Cmd == CmdStop && (req.Force || req.Timeout > 0) {
+				go func(client clientRecord) {
+					replies <- self.stopClient(client, req)
+				}(client)
+				continue
+			}
+
+			client := client // the callback is called after the iteration
+			msg := ControlMessage{Cmd: req.Cmd, Timeout: req.Timeout}
+			self.sendRequest(client, msg, func(reply ControlMessage) {
+				if req.Cmd == CmdReload && reply.Error == "" {
+					self.publishEvent(EventReloaded, client.App, client.Pid, "")
+				} else if req.Cmd == CmdStop && reply.Code == ErrAppNotRunning {
+					// The app exited without replying, which is fine, see `stopClient()`.
+					reply = newReply(reply)
+					reply.Text = fmt.Sprintf("app [%s] stopped", client.App)
+				}
+
+				replies <- reply
+			})
+		}
+
+		for range wave {
+			reply := <-replies
+			forwarded++
+			reply.MsgId = req.MsgId
+			reply.Fin = forwarded == total
+			conn.Write(EncodeMessage(reply))
+		}
+	}
+}
+
+// sendRequest sends the command to the client with a new `MsgId`, `fn` is called with the reply,
+// or with an error if the client disconnects before replying. It returns the `MsgId`.
+func (self *Host) sendRequest(
+	client clientRecord,
+	msg ControlMessage,
+	fn func(reply ControlMessage),
+) string {
+	msg.MsgId = newMsgId()
+	self.callbacks.Set(msg.MsgId, callbackRecord{conn: client.conn, app: client.App, fn: fn})
+
+	if _, err := client.conn.Write(EncodeMessage(msg)); err != nil {
+		// The client has disconnected, maybe after its callbacks were canceled.
+		self.cancelCallback(msg.MsgId)
+	}
+
+	return msg.MsgId
+}
+
+// cancelCallbacks cancels the pending callbacks of the commands sent via the connection, since the
+// client has disconnected and will never reply.
+func (self *Host) cancelCallbacks(conn net.Conn) {
+	for _, msgId := range self.callbacks.Keys() {
+		if record, ok := self.callbacks.Get(msgId); ok && record.conn == conn {
+			self.cancelCallback(msgId)
+		}
+	}
+}
+
+// cancelCallback calls the callback with an error, unless the reply has just arrived.
+func (self *Host) cancelCallback(msgId string) {
+	if record, ok := self.callbacks.Pop(msgId); ok {
+		record.fn(newErrorReply(ControlMessage{App: record.app, MsgId: msgId},
+			newProtocolError(ErrAppNotRunning, "app [%s] disconnected without replying",
+				record.app)))
+	}
+}
+
+// groupClients groups the clients by the `dependsOn` of their apps, see `config.GroupApps()`.
+func (self *Host) groupClients(clients []clientRecord) [][]clientRecord {
+	allApps := self.getApps()
+	apps := slicex.Map(clients, func(client clientRecord, _ int) config.App {
+		app, ok := slicex.Find(allApps, func(item config.App, _ int) bool {
+			return item.Name == client.App
+		})
+
+		if !ok {
+			app = config.App{Name: client.App}
+		}
+
+		return app
+	})
+	groups, err := config.GroupApps(apps)
+
+	if err != nil {
+		return [][]clientRecord{clients}
 	}
+
+	return slicex.Map(groups, func(group []config.App, _ int) []clientRecord {
+		return slicex.Filter(clients, func(client clientRecord, _ int) bool {
+			return slices.ContainsFunc(group, func(app config.App) bool {
+				return app.Name == client.App
+			})
+		})
+	})
 }
 
 func (self *Host) handleHandshake(conn net.Conn, msg ControlMessage) {
 	// After a guest establish the socket connection, it sends a `handshake` command indicates a
 	// signing-in, we then store the client in the `hostClients` property for broadcast purposes.
 
+	if !self.checkVersion(conn, msg) {
+		return
+	}
+
 	if msg.App != "" {
 		self.addClient(clientRecord{
 			conn:      conn,
 			App:       msg.App,
+			Url:       msg.Url,
 			Pid:       msg.Pid,
 			StartTime: int(time.Now().Unix()),
 		})
@@ -383,28 +881,78 @@ func (self *Host) handleHandshake(conn net.Conn, msg ControlMessage) {
 		})
 	}
 
-	conn.Write(EncodeMessage(ControlMessage{Cmd: "handshake"}))
+	conn.Write(EncodeMessage(ControlMessage{
+		Cmd:     CmdHandshake,
+		MsgId:   msg.MsgId,
+		Version: ProtocolVersion,
+	}))
 
 	if msg.App != "" && msg.App != ":cli" {
-		cli, ok := self.findClient(func(client clientRecord) bool {
-			return client.App == ":cli"
+		// Tell the new guest which instances are drained.
+		drained := self.filterClients(func(item clientRecord) bool {
+			return item.Drained
 		})
 
-		if ok {
-			cli.conn.Write(EncodeMessage(ControlMessage{
-				Cmd: "online",
-				App: msg.App,
-				Pid: msg.Pid,
-			}))
+		for _, client := range drained {
+			conn.Write(EncodeMessage(ControlMessage{Cmd: CmdDrain, App: client.App}))
 		}
 	}
+
+	if msg.App != "" {
+		// If the app has been marked as errored, it must be started manually, clear the crash
+		// history so it can be restarted again.
+		self.restartsLock.Lock()
+		if record, ok := self.restarts[msg.App]; ok && record.Errored {
+			record.reset()
+		}
+		self.restartsLock.Unlock()
+	}
+}
+
+func (self *Host) handleReady(conn net.Conn, msg ControlMessage) {
+	// After a guest app finishes its initialization, it sends a `ready` command, only then the app
+	// is considered online and its instance is reported to the watchers.
+	client, exists := self.markClientReady(conn)
+
+	if !exists || client.App == "" || client.App == ":cli" {
+		return
+	}
+
+	self.notifyWatchers(client.App)
+	self.publishEvent(EventOnline, client.App, client.Pid, "")
+
+	// Several CLI commands may be running at the same time, notify all of them.
+	clis := self.filterClients(func(client clientRecord) bool {
+		return client.App == ":cli"
+	})
+
+	for _, cli := range clis {
+		cli.conn.Write(EncodeMessage(ControlMessage{
+			Cmd: CmdOnline,
+			App: client.App,
+			Pid: client.Pid,
+		}))
+	}
 }
 
 func (self *Host) handleGoodbye(conn net.Conn, msg ControlMessage) {
-	self.removeClient(func(client clientRecord) bool {
+	client, exists := self.findClient(func(client clientRecord) bool {
 		return client.conn == conn
 	})
 
+	if exists {
+		self.removeClient(func(item clientRecord) bool {
+			return item.conn == conn
+		})
+		self.markStopping(client.App)
+		self.notifyWatchers(client.App)
+		self.publishEvent(EventOffline, client.App, client.Pid, "")
+
+		if client.Drained {
+			self.broadcastDrain(client.App, false)
+		}
+	}
+
 	if msg.Fin {
 		conn.Close()
 	}
@@ -415,10 +963,10 @@ func (self *Host) handleReply(conn net.Conn, msg ControlMessage) {
 	// we use the `msgId` to retrieve the callback, run it and remove it.
 
 	if msg.MsgId != "" {
-		fn, ok := self.callbacks.Pop(msg.MsgId)
+		record, ok := self.callbacks.Pop(msg.MsgId)
 
 		if ok {
-			fn(msg)
+			record.fn(msg)
 		}
 	}
 
@@ -428,27 +976,79 @@ func (self *Host) handleReply(conn net.Conn, msg ControlMessage) {
 }
 
 // NOTE: this function runs in the CLI instead of the host server.
-func (self *Host) startApp(appName string, guest *Guest) {
-	conf, err := config.LoadConfig()
+func (self *Host) startApp(appName string, guest *Guest) error {
+	apps, err := self.loadApps(appName)
+
+	if len(apps) == 0 || err != nil {
+		guest.Leave("", "")
+		return err
+	}
+
+	return self.startApps(apps, guest)
+}
+
+// startApps spawns the apps group by group and leaves the group once done, the apps must have been
+// prepared, see `prepareApps()`.
+//
+// NOTE: this function runs in the CLI instead of the host server.
+func (self *Host) startApps(apps []config.App, guest *Guest) error {
+	groups, err := config.GroupApps(apps)
 
 	if err != nil {
-		fmt.Println(err)
 		guest.Leave("", "")
-		return
+		return err
 	}
 
-	apps := []config.App{}
-	start := func(app config.App) bool {
-		_, err := SpawnApp(app, self.tsCfg)
+	// Start the apps group by group in the order of their dependencies, an app is only started
+	// after all the apps it depends on are online.
+	failed := []string{}
 
-		if err != nil {
-			fmt.Printf("unable to start app [%s] (reason: %s)\n", app.Name, err)
-			return false
-		} else {
-			return true
+	for _, group := range groups {
+		toStart := []config.App{}
+
+		for _, app := range group {
+			dep, ok := slicex.Find(failed, func(name string, _ int) bool {
+				return slices.ContainsFunc(app.DependsOn, func(target string) bool {
+					return config.MatchApp(name, target)
+				})
+			})
+
+			if ok {
+				fmt.Printf("app [%s] is not started since its dependency [%s] failed\n",
+					app.Name, dep)
+				failed = append(failed, app.Name)
+			} else {
+				toStart = append(toStart, app)
+			}
 		}
+
+		if len(toStart) > 0 {
+			failed = append(failed, self.spawnAndWait(toStart, guest)...)
+		}
+	}
+
+	guest.Leave("", "")
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to start %d app(s): %s", len(failed), strings.Join(failed, ", "))
 	}
 
+	return nil
+}
+
+// loadApps loads the served apps matching the `appName` (or all served apps if it's empty) from the
+// config file, and compiles / builds their entries so they're ready to be spawned.
+//
+// NOTE: this function runs in the CLI instead of the host server.
+func (self *Host) loadApps(appName string) ([]config.App, error) {
+	conf, err := config.LoadConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	apps := []config.App{}
+
 	if appName == "" {
 		for _, app := range conf.Apps {
 			if app.Serve {
@@ -456,80 +1056,187 @@ func (self *Host) startApp(appName string, guest *Guest) {
 			}
 		}
 	} else {
-		app, exists := slicex.Find(conf.Apps, func(item config.App, idx int) bool {
-			return item.Name == appName
+		// The app name can be either the base name or a specific replica.
+		matches := slicex.Filter(conf.Apps, func(item config.App, idx int) bool {
+			return config.MatchApp(item.Name, appName)
 		})
 
-		if !exists {
-			fmt.Printf("app [%s] doesn't exist in the config file\n", appName)
-		} else if !app.Serve {
-			fmt.Printf("app [%s] is not intended to be served\n", appName)
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("app [%s] doesn't exist in the config file", appName)
+		} else if !matches[0].Serve {
+			return nil, fmt.Errorf("app [%s] is not intended to be served", appName)
 		} else {
-			apps = append(apps, app)
+			apps = append(apps, matches...)
 		}
 	}
 
-	numStarted := 0
+	if len(apps) == 0 {
+		return apps, nil
+	} else if err := self.prepareApps(apps); err != nil {
+		return nil, err
+	}
 
-	if len(apps) != 0 {
-		tsApp, ok := slicex.Find(apps, func(app config.App, _ int) bool {
-			return filepath.Ext(app.Entry) == ".ts"
-		})
-		var err error
+	return apps, nil
+}
 
-		if ok {
-			outDir, _ := ResolveTsEntry(tsApp.Entry, self.tsCfg)
-			err = CompileTs(self.tsCfg, outDir)
-		}
+// prepareApps compiles the TypeScript sources and builds the Go entries of the apps, so they're
+// ready to be spawned.
+//
+// NOTE: this function runs in the CLI, or in the host server when it resurrects the apps.
+func (self *Host) prepareApps(apps []config.App) error {
+	tsApp, ok := slicex.Find(apps, func(app config.App, _ int) bool {
+		return filepath.Ext(app.Entry) == ".ts"
+	})
 
-		if err == nil {
-			for _, app := range apps {
-				ok := start(app)
+	if ok {
+		outDir, _ := ResolveTsEntry(tsApp.Entry, self.tsCfg)
 
-				if ok {
-					numStarted++
-				}
-			}
+		if err := CompileTs(self.tsCfg, outDir); err != nil {
+			return err
 		}
 	}
 
-	if numStarted == 0 {
-		guest.Leave("", "")
-		return
+	// Build each distinct Go entry once, the binaries are sent to the host server along with the
+	// `spawn` commands, see `resolveGoBinary()`.
+	goEntries := slicex.Uniq(slicex.Map(slicex.Filter(apps, func(app config.App, _ int) bool {
+		return filepath.Ext(app.Entry) == ".go"
+	}), func(app config.App, _ int) string {
+		return app.Entry
+	}))
+
+	for _, entry := range goEntries {
+		binary, err := BuildGoEntry(entry, self.options.Rebuild)
+
+		if err != nil {
+			return err
+		}
+
+		self.binaries.Set(entry, binary)
 	}
 
-	waitChan := make(chan int)
-	count := 0
+	return nil
+}
 
-	go func() {
-		for {
-			msg := <-guest.replyChan
+// spawnAndWait asks the host server to spawn the apps and waits for them to come online, it returns
+// the names of the apps that failed to start.
+//
+// NOTE: this function runs in the CLI instead of the host server.
+func (self *Host) spawnAndWait(apps []config.App, guest *Guest) []string {
+	// Ask the host server to spawn the apps, so it can supervise the processes.
+	requests := slicex.Map(apps, func(app config.App, _ int) ControlMessage {
+		// The env is sent along, in case it's different from the config file, e.g. resurrected.
+		binary, _ := self.binaries.Get(app.Entry)
+		return ControlMessage{
+			Cmd:    CmdSpawn,
+			App:    app.Name,
+			MsgId:  newMsgId(),
+			Env:    app.Env,
+			Binary: binary,
+		}
+	})
+	guest.Send(requests...)
+
+	numReplied := 0
+	pending := map[string]time.Time{} // the deadlines of the apps that are not online yet
+	online := map[string]bool{}
+	failed := []string{}
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+
+	for numReplied < len(apps) || len(pending) > 0 {
+		select {
+		case msg := <-guest.replyChan:
+			app, ok := slicex.Find(apps, func(item config.App, _ int) bool {
+				return item.Name == msg.App
+			})
 
-			if msg.Cmd == "online" {
-				log.Printf("app [%s] started (pid: %d)", msg.App, msg.Pid)
-				count++
+			if !ok {
+				continue // the message is about an app that is not started in this group
+			} else if msg.Cmd == CmdReply {
+				if !slices.ContainsFunc(requests, func(req ControlMessage) bool {
+					return req.MsgId == msg.MsgId
+				}) {
+					continue // not a response of the spawn requests
+				}
 
-				if count == numStarted {
-					break
+				numReplied++
+
+				if msg.Error != "" {
+					fmt.Printf("unable to start app [%s] (reason: %s)\n", msg.App, msg.Error)
+					failed = append(failed, msg.App)
+				} else if !online[msg.App] {
+					pending[msg.App] = time.Now().Add(getStartTimeout(app))
+				}
+			} else if msg.Cmd == CmdOnline {
+				log.Printf("app [%s] started (pid: %d)", msg.App, msg.Pid)
+				online[msg.App] = true
+				delete(pending, msg.App)
+			}
+		case now := <-ticker.C:
+			for _, app := range apps {
+				if deadline, ok := pending[app.Name]; ok && now.After(deadline) {
+					delete(pending, app.Name)
+					failed = append(failed, app.Name)
+					reportStartFailure(app)
 				}
 			}
 		}
+	}
 
-		waitChan <- 0
-	}()
+	return failed
+}
 
-	<-waitChan
-	guest.Leave("", "")
+func getStartTimeout(app config.App) time.Duration {
+	if app.StartTimeout > 0 {
+		return time.Duration(app.StartTimeout) * time.Millisecond
+	} else {
+		return defaultStartTimeout
+	}
+}
+
+// reportStartFailure prints the tail of the app's log, which usually tells why the app failed to
+// start.
+func reportStartFailure(app config.App) {
+	fmt.Printf("app [%s] didn't come online within %v\n", app.Name, getStartTimeout(app))
+
+	logFile := app.Stderr
+
+	if logFile == "" {
+		logFile = app.Stdout
+	}
+
+	if logFile == "" {
+		return
+	}
+
+	lines, err := util.TailFile(logFile, numLogLinesOnFailure)
+
+	if err != nil || len(lines) == 0 {
+		return
+	}
+
+	fmt.Printf("last %d lines of %s:\n", len(lines), logFile)
+
+	for _, line := range lines {
+		fmt.Println("    " + line)
+	}
 }
 
 // NOTE: this function runs in the CLI instead of the host server.
-func (self *Host) listApps(records []clientRecord) {
+func (self *Host) listApps(records []clientRecord, restarts []restartRecord, appName string) {
 	var list []appStat
 
 	for _, app := range self.apps {
+		if appName != "" && !config.MatchApp(app.Name, appName) {
+			continue
+		}
+
 		item, exists := slicex.Find(records, func(item clientRecord, idx int) bool {
 			return item.App == app.Name
 		})
+		restart, _ := slicex.Find(restarts, func(item restartRecord, idx int) bool {
+			return item.App == app.Name
+		})
 
 		if exists {
 			var memory float64
@@ -544,33 +1251,59 @@ func (self *Host) listApps(records []clientRecord) {
 				cpu = stat.CPU
 			}
 
+			url := app.Url
+
+			if item.Url != "" {
+				url = item.Url // use the actual URL in case the app is served on a dynamic port
+			}
+
 			list = append(list, appStat{
-				app:    app.Name,
-				url:    app.Url,
-				pid:    item.Pid,
-				uptime: int(time.Now().Unix()) - item.StartTime,
-				memory: memory,
-				cpu:    cpu,
+				app:          app.Name,
+				url:          url,
+				pid:          item.Pid,
+				uptime:       int(time.Now().Unix()) - item.StartTime,
+				memory:       memory,
+				cpu:          cpu,
+				ready:        item.Ready,
+				unhealthy:    item.Unhealthy,
+				unresponsive: item.Unresponsive,
+				drained:      item.Drained,
+				restarts:     restart.Restarts,
+				lastCrash:    restart.LastCrash,
 			})
 		} else if app.Serve {
 			list = append(list, appStat{
-				app:    app.Name,
-				url:    app.Url,
-				pid:    -1,
-				uptime: -1,
-				memory: -1,
-				cpu:    -1,
+				app:       app.Name,
+				url:       app.Url,
+				pid:       -1,
+				uptime:    -1,
+				memory:    -1,
+				cpu:       -1,
+				errored:   restart.Errored,
+				restarts:  restart.Restarts,
+				lastCrash: restart.LastCrash,
 			})
 		}
 	}
 
-	tb := table.New("App", "URL", "Status", "Pid", "Uptime", "Memory", "CPU")
+	tb := table.New("App", "URL", "Status", "Pid", "Uptime", "Memory", "CPU", "Restarts",
+		"Last Crash")
 
 	for _, item := range list {
 		parts := []any{item.app, item.url}
 
-		if item.pid == -1 {
+		if item.errored {
+			parts = append(parts, "errored", "N/A")
+		} else if item.pid == -1 {
 			parts = append(parts, "stopped", "N/A")
+		} else if !item.ready {
+			parts = append(parts, "starting", fmt.Sprint(item.pid))
+		} else if item.unresponsive {
+			parts = append(parts, "unresponsive", fmt.Sprint(item.pid))
+		} else if item.unhealthy {
+			parts = append(parts, "unhealthy", fmt.Sprint(item.pid))
+		} else if item.drained {
+			parts = append(parts, "drained", fmt.Sprint(item.pid))
 		} else {
 			parts = append(parts, "running", fmt.Sprint(item.pid))
 		}
@@ -593,6 +1326,14 @@ func (self *Host) listApps(records []clientRecord) {
 			parts = append(parts, fmt.Sprintf("%.2f %%", item.cpu))
 		}
 
+		parts = append(parts, fmt.Sprint(item.restarts))
+
+		if item.lastCrash == 0 {
+			parts = append(parts, "N/A")
+		} else {
+			parts = append(parts, time.Unix(int64(item.lastCrash), 0).Format("2006-01-02T15:04:05"))
+		}
+
 		tb.AddRow(parts...)
 	}
 
@@ -600,7 +1341,7 @@ func (self *Host) listApps(records []clientRecord) {
 }
 
 // NOTE: this function runs in the CLI instead of the host server.
-func (self *Host) sendCommand(cmd string, appName string) {
+func (self *Host) sendCommand(cmd string, appName string) error {
 	guest := NewGuest(config.App{
 		Name: ":cli",
 		Url:  "",
@@ -609,19 +1350,48 @@ func (self *Host) sendCommand(cmd string, appName string) {
 	err := guest.connect()
 
 	if err != nil {
-		if cmd == "list" {
-			self.listApps([]clientRecord{})
+		var protoErr *ProtocolError
+
+		// The host server is not running, unless it's rejected.
+		if cmd == "list" && !errors.As(err, &protoErr) {
+			self.listApps([]clientRecord{}, []restartRecord{}, appName)
+			err = nil
 		}
 
 		guest.Leave("", "")
-		return
+		return err
 	}
 
 	if cmd == "start" {
-		self.startApp(appName, guest)
+		return self.startApp(appName, guest)
+	} else if cmd == "restart" && self.options.Rolling {
+		return self.rollingRestart(appName, guest)
 	} else if cmd == "restart" {
-		self.sendAndWait(ControlMessage{Cmd: "stop", App: appName}, guest, false)
-		self.startApp(appName, guest)
+		err := self.sendAndWait(ControlMessage{Cmd: CmdStop, App: appName}, guest, false)
+
+		if err != nil {
+			log.Println(err)
+		}
+
+		return self.startApp(appName, guest)
+	} else if cmd == "save" {
+		return self.saveApps(guest)
+	} else if cmd == "resurrect" {
+		return self.resurrectApps(guest)
+	} else if cmd == "drain" && self.options.Stop {
+		err := self.sendAndWait(ControlMessage{
+			Cmd:     CmdDrain,
+			App:     appName,
+			Timeout: int(self.options.Timeout.Milliseconds()),
+		}, guest, false)
+
+		// Don't stop the app if it's not drained, otherwise the in-flight calls would be cut off.
+		if err != nil {
+			guest.Leave("", "")
+			return err
+		}
+
+		return self.sendAndWait(ControlMessage{Cmd: CmdStop, App: appName}, guest, true)
 	} else {
 		if cmd == "reload" {
 			conf, err := config.LoadConfig()
@@ -632,7 +1402,7 @@ func (self *Host) sendCommand(cmd string, appName string) {
 
 				if appName != "" {
 					app, ok := slicex.Find(conf.Apps, func(app config.App, idx int) bool {
-						return app.Name == appName
+						return config.MatchApp(app.Name, appName)
 					})
 
 					if ok && filepath.Ext(app.Entry) == ".ts" {
@@ -652,69 +1422,133 @@ func (self *Host) sendCommand(cmd string, appName string) {
 			}
 
 			if err != nil {
-				fmt.Println(err)
 				guest.Leave("", "")
-				return
+				return err
 			}
 		}
 
-		self.sendAndWait(ControlMessage{Cmd: cmd, App: appName}, guest, true)
+		msg := ControlMessage{Cmd: Command(cmd), App: appName}
+
+		if cmd == "stop" {
+			msg.Timeout = int(self.options.Timeout.Milliseconds())
+			msg.Force = self.options.Force
+		} else if cmd == "drain" {
+			msg.Timeout = int(self.options.Timeout.Milliseconds())
+		}
+
+		return self.sendAndWait(msg, guest, true)
 	}
 }
 
+// sendAndWait sends the request to the host server and prints the replies correlated to it, until
+// the final one is received. The error of the first failed reply is returned instead of printed, so
+// the caller can decide how to report it, the errors of the others are printed as usual.
+//
 // NOTE: this function runs in the CLI instead of the host server.
-func (self *Host) sendAndWait(msg ControlMessage, guest *Guest, fin bool) {
-	guest.Send(msg)
-	waitChan := make(chan int)
+func (self *Host) sendAndWait(msg ControlMessage, guest *Guest, fin bool) error {
+	msg.MsgId = guest.request(msg)
+	errChan := make(chan error)
 
 	go func() {
+		var err error
+
 		for {
-			msg := <-guest.replyChan
-
-			if msg.Error != "" {
-				log.Println(msg.Error)
-			} else if msg.Text != "" {
-				log.Println(msg.Text)
-			} else if msg.Guests != nil {
-				self.listApps(msg.Guests)
+			reply := <-guest.replyChan
+
+			if reply.MsgId != msg.MsgId && reply.Cmd != CmdGoodbye {
+				continue // not a response of this request, e.g. `online`
+			} else if reply.Error != "" && err == nil {
+				err = reply.Err()
+			} else if reply.Error != "" {
+				log.Println(reply.Error)
+			} else if reply.Text != "" {
+				log.Println(reply.Text)
+			} else if reply.Guests != nil {
+				self.listApps(reply.Guests, reply.Restarts, msg.App)
 			}
 
-			if msg.Fin {
+			if reply.Fin {
 				break
 			}
 		}
 
-		waitChan <- 0
+		errChan <- err
 	}()
 
-	<-waitChan
+	err := <-errChan
 
 	if fin {
-		if msg.Cmd == "stop" && msg.App == "" {
+		if msg.Cmd == CmdStop && msg.App == "" {
 			// After all the apps have been stopped, stop the host server as well.
-			self.sendAndWait(ControlMessage{Cmd: "stop-host"}, guest, true)
+			self.sendAndWait(ControlMessage{Cmd: CmdStopHost}, guest, true)
 			time.Sleep(time.Microsecond * 20) // wait a while for the host to stop
 			log.Println("host server shut down")
 		} else {
 			guest.Leave("", "")
 		}
 	}
+
+	return err
 }
 
-func SendCommand(cmd string, appName string) {
+// SendCommand sends the command to the host server and waits for the apps to finish it, the
+// returned error indicates that the command couldn't be sent or some apps failed to start.
+func SendCommand(cmd string, appName string, options ...CommandOptions) error {
 	config, err := config.LoadConfig()
 
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
 
 	host := NewHost(config, true)
-	host.sendCommand(cmd, appName)
+
+	if len(options) > 0 {
+		host.options = options[0]
+	}
+
+	return host.sendCommand(cmd, appName)
 }
 
+// SpawnApp starts the app in a detached process, the process is not supervised, use the host server
+// to start the app if supervision is needed.
 func SpawnApp(app config.App, tsCfg config.TsConfig) (int, error) {
 	return goext.Try(func() int {
+		var stdout io.Writer
+		var stderr io.Writer
+
+		// The process outlives this program, so it writes to the log files directly.
+		if app.Stdout != "" {
+			file := goext.Ok(os.OpenFile(app.Stdout, openForAppend, 0644))
+			defer file.Close()
+			stdout = file
+		}
+
+		if app.Stderr != "" {
+			file := goext.Ok(os.OpenFile(app.Stderr, openForAppend, 0644))
+			defer file.Close()
+			stderr = file
+		} else {
+			stderr = stdout
+		}
+
+		cmd := goext.Ok(startProcess(app, tsCfg, "", stdout, stderr))
+		pid := cmd.Process.Pid
+		goext.Ok(0, cmd.Process.Release())
+
+		return pid
+	})
+}
+
+// startProcess starts the app, a Go app is started with the `binary` built from its entry, or the
+// entry is built if it's empty.
+func startProcess(
+	app config.App,
+	tsCfg config.TsConfig,
+	binary string,
+	stdout io.Writer,
+	stderr io.Writer,
+) (*exec.Cmd, error) {
+	return goext.Try(func() *exec.Cmd {
 		if app.Entry == "" {
 			panic("entry file is not set")
 		}
@@ -724,7 +1558,12 @@ func SpawnApp(app config.App, tsCfg config.TsConfig) (int, error) {
 		ext := filepath.Ext(entry)
 
 		if ext == ".go" {
-			cmd = exec.Command("go", "run", entry, app.Name)
+			// Spawn the built binary instead of using `go run`, so the pid is the app's own.
+			if binary == "" {
+				binary = goext.Ok(BuildGoEntry(entry, false))
+			}
+
+			cmd = exec.Command(binary, app.Name)
 		} else if ext == ".js" {
 			cmd = exec.Command("node", "-r", "source-map-support/register", entry, app.Name)
 		} else {
@@ -732,15 +1571,11 @@ func SpawnApp(app config.App, tsCfg config.TsConfig) (int, error) {
 			cmd = exec.Command(filepath.Join(cwd, entry), app.Name)
 		}
 
-		if app.Stdout != "" {
-			cmd.Stdout = goext.Ok(os.OpenFile(app.Stdout, openForAppend, 0644))
-		}
-
-		if app.Stderr != "" {
-			cmd.Stderr = goext.Ok(os.OpenFile(app.Stderr, openForAppend, 0644))
-		} else if app.Stdout != "" {
-			cmd.Stderr = goext.Ok(os.OpenFile(app.Stdout, openForAppend, 0644))
-		}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		// If the output is piped, don't wait for the descendant processes that inherit the pipes
+		// after the app exits.
+		cmd.WaitDelay = time.Second
 
 		if len(env) > 0 {
 			cmd.Env = os.Environ()
@@ -751,10 +1586,8 @@ func SpawnApp(app config.App, tsCfg config.TsConfig) (int, error) {
 		}
 
 		goext.Ok(0, cmd.Start())
-		pid := cmd.Process.Pid
-		goext.Ok(0, cmd.Process.Release())
 
-		return pid
+		return cmd
 	})
 }
 
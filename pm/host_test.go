@@ -29,8 +29,8 @@ func TestNewHost(t *testing.T) {
 	host := NewHost(config, false)
 
 	assert.Equal(t, config.Apps, host.apps)
-	assert.Equal(t, 0, host.state)
-	assert.Equal(t, []clientRecord{}, host.clients)
+	assert.Equal(t, int32(0), host.state.Load())
+	assert.Equal(t, []clientRecord{}, host.getClients())
 	assert.Equal(t, 0, host.callbacks.Size())
 }
 
@@ -46,7 +46,7 @@ func TestHost_Start(t *testing.T) {
 	defer host.Stop()
 
 	assert.Nil(t, err)
-	assert.Equal(t, 1, host.state)
+	assert.Equal(t, int32(1), host.state.Load())
 	assert.NotNil(t, host.server)
 	assert.Equal(t, filepath.Join(goext.Ok(os.Getwd()), "ngrpc.sock"), host.sockFile)
 
@@ -59,7 +59,7 @@ func TestHost_Start(t *testing.T) {
 		assert.Contains(t, err2.Error(), "address already in use")
 	}
 
-	assert.Equal(t, 0, host2.state)
+	assert.Equal(t, int32(0), host2.state.Load())
 	assert.Nil(t, host2.server)
 	assert.Equal(t, "", host2.sockFile)
 }
@@ -82,7 +82,7 @@ func TestHost_Stop(t *testing.T) {
 
 	host.Stop()
 
-	assert.Equal(t, 0, host.state)
+	assert.Equal(t, int32(0), host.state.Load())
 
 	if runtime.GOOS != "windows" {
 		assert.False(t, util.Exists(host.sockFile))
@@ -109,8 +109,8 @@ func TestSendCommand_stop(t *testing.T) {
 	})
 	guest.Join()
 
-	assert.Equal(t, 1, guest.state)
-	assert.Equal(t, 1, len(host.clients))
+	assert.Equal(t, int32(1), guest.state.Load())
+	assert.Equal(t, 1, len(host.getClients()))
 
 	go func() {
 		SendCommand("stop", "example-server")
@@ -120,10 +120,162 @@ func TestSendCommand_stop(t *testing.T) {
 	guest.Leave("app [example-server] stopped", msgId)
 
 	assert.NotEqual(t, "", msgId)
-	assert.Equal(t, 2, guest.state)
+	assert.Equal(t, int32(2), guest.state.Load())
 
 	time.Sleep(time.Millisecond * 10)
-	assert.Equal(t, 0, len(host.clients))
+	assert.Equal(t, 0, len(host.getClients()))
+}
+
+func TestSendCommand_stopWithoutReply(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	defer os.Remove("ngrpc.json")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	// The app is not in the config file, so it's not revived.
+	var guest *Guest
+	guest = NewGuest(config.App{Name: "lost-app"}, func(msgId string) {
+		guest.getConn().Close() // the app dies before replying
+	})
+	guest.Join()
+	defer guest.Leave("", "")
+
+	done := make(chan error)
+
+	go func() {
+		done <- SendCommand("stop", "lost-app")
+	}()
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the command didn't finish after the app disconnected")
+	}
+
+	assert.Equal(t, 0, host.callbacks.Size())
+}
+
+func TestSendCommand_stopReplicas(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	goext.Ok(0, util.CopyFile("../tsconfig.json", "tsconfig.json"))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("tsconfig.json")
+
+	conf := goext.Ok(config.LoadConfig())
+	host := NewHost(conf, false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	c := make(chan []string)
+
+	msgIds := []string{}
+	push := async.Queue(func(msgId string) (fin bool) {
+		msgIds = append(msgIds, msgId)
+		fin = len(msgIds) == 2
+
+		if fin {
+			c <- msgIds
+		}
+		return fin
+	})
+
+	guest1 := NewGuest(config.App{
+		Name: "user-server#0",
+		Url:  "grpc://localhost:4000",
+	}, push)
+	guest2 := NewGuest(config.App{
+		Name: "user-server#1",
+		Url:  "grpc://localhost:4001",
+	}, push)
+	guest3 := NewGuest(config.App{
+		Name: "post-server",
+		Url:  "grpc://localhost:4002",
+	}, func(msgId string) {})
+	guest1.Join()
+	guest2.Join()
+	guest3.Join()
+
+	go func() {
+		SendCommand("stop", "user-server")
+	}()
+
+	<-c
+	guest1.Leave("app [user-server#0] stopped", msgIds[0])
+	guest2.Leave("app [user-server#1] stopped", msgIds[1])
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 1, len(host.getClients()))
+	assert.Equal(t, "post-server", host.getClients()[0].App)
+
+	guest3.Leave("app [post-server] stopped", "")
+	time.Sleep(time.Millisecond * 10)
+}
+
+func TestSendCommand_stopInOrder(t *testing.T) {
+	conf := `{"apps":[` +
+		`{"name":"user-server","url":"grpc://localhost:4001","dependsOn":["post-server"]},` +
+		`{"name":"post-server","url":"grpc://localhost:4002"}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	defer os.Remove("ngrpc.json")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	order := make(chan string, 2)
+	var userGuest *Guest
+	var postGuest *Guest
+
+	postGuest = NewGuest(config.App{
+		Name: "post-server",
+		Url:  "grpc://localhost:4002",
+	}, func(msgId string) {
+		order <- "post-server"
+		postGuest.Leave("app [post-server] stopped", msgId)
+	})
+	userGuest = NewGuest(config.App{
+		Name: "user-server",
+		Url:  "grpc://localhost:4001",
+	}, func(msgId string) {
+		order <- "user-server"
+		time.Sleep(time.Millisecond * 50) // the dependency must wait for this app to stop
+		userGuest.Leave("app [user-server] stopped", msgId)
+	})
+	postGuest.Join()
+	userGuest.Join()
+
+	SendCommand("stop", "")
+
+	assert.Equal(t, "user-server", <-order)
+	assert.Equal(t, "post-server", <-order)
+}
+
+func TestResolveAppUrl(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	goext.Ok(0, util.CopyFile("../tsconfig.json", "tsconfig.json"))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("tsconfig.json")
+
+	conf := goext.Ok(config.LoadConfig())
+	host := NewHost(conf, false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	guest := NewGuest(config.App{
+		Name: "example-server",
+		Url:  "grpc://localhost:34567",
+	}, func(msgId string) {})
+	guest.Join()
+	defer guest.Leave("", "")
+
+	url := goext.Ok(ResolveAppUrl("example-server"))
+	assert.Equal(t, "grpc://localhost:34567", url)
+
+	_, err := ResolveAppUrl("user-server")
+	assert.Equal(t, "app [user-server] is not running", err.Error())
 }
 
 func TestSendCommand_stopAll(t *testing.T) {
@@ -170,7 +322,7 @@ func TestSendCommand_stopAll(t *testing.T) {
 	guest2.Leave("app [user-server] stopped", msgIds[1])
 
 	time.Sleep(time.Second) // after a second, all clients shall be closed, including the :cli
-	assert.Equal(t, 0, len(host.clients))
+	assert.Equal(t, 0, len(host.getClients()))
 }
 
 func TestSendCommand_list(t *testing.T) {
@@ -232,6 +384,48 @@ func TestSendCommand_list(t *testing.T) {
 	time.Sleep(time.Microsecond * 10)
 }
 
+func TestHost_ready(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	goext.Ok(0, util.CopyFile("../tsconfig.json", "tsconfig.json"))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("tsconfig.json")
+
+	conf := goext.Ok(config.LoadConfig())
+	host := NewHost(conf, false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	cli := NewGuest(config.App{Name: ":cli"}, func(msgId string) {})
+	cli.replyChan = make(chan ControlMessage, 10)
+	goext.Ok(0, cli.connect())
+	defer cli.Leave("", "")
+
+	guest := NewGuest(config.App{
+		Name: "example-server",
+		Url:  "grpc://localhost:4000",
+	}, func(msgId string) {})
+	guest.Join()
+	defer guest.Leave("", "")
+
+	time.Sleep(time.Millisecond * 10)
+	client, _ := host.findClient(func(item clientRecord) bool {
+		return item.App == "example-server"
+	})
+	assert.False(t, client.Ready)
+	assert.Equal(t, 0, len(cli.replyChan)) // not online until ready
+
+	guest.Ready()
+	msg := <-cli.replyChan
+	assert.Equal(t, CmdOnline, msg.Cmd)
+	assert.Equal(t, "example-server", msg.App)
+	assert.Equal(t, os.Getpid(), msg.Pid)
+
+	client, _ = host.findClient(func(item clientRecord) bool {
+		return item.App == "example-server"
+	})
+	assert.True(t, client.Ready)
+}
+
 func TestSendCommand_stopHost(t *testing.T) {
 	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
 	goext.Ok(0, util.CopyFile("../tsconfig.json", "tsconfig.json"))
@@ -249,8 +443,8 @@ func TestSendCommand_stopHost(t *testing.T) {
 	}, func(msgId string) {})
 	guest.Join()
 
-	assert.Equal(t, 1, guest.state)
-	assert.Equal(t, 1, len(host.clients))
+	assert.Equal(t, int32(1), guest.state.Load())
+	assert.Equal(t, 1, len(host.getClients()))
 
 	go func() {
 		SendCommand("stop-host", "")
@@ -258,9 +452,9 @@ func TestSendCommand_stopHost(t *testing.T) {
 
 	time.Sleep(time.Second) // after a second, all clients shall be closed, including the :cli
 
-	assert.Equal(t, 0, guest.state)
-	assert.Equal(t, 0, host.state)
-	assert.Equal(t, 0, len(host.clients))
+	assert.Equal(t, int32(0), guest.state.Load())
+	assert.Equal(t, int32(0), host.state.Load())
+	assert.Equal(t, 0, len(host.getClients()))
 }
 
 func TestCommand_listWhenNoHost(t *testing.T) {
@@ -274,15 +468,18 @@ func TestCommand_listWhenNoHost(t *testing.T) {
 	lines := strings.Split(out, "\n")
 
 	assert.Equal(t,
-		[]string{"App", "URL", "Status", "Pid", "Uptime", "Memory", "CPU"},
+		[]string{"App", "URL", "Status", "Pid", "Uptime", "Memory", "CPU", "Restarts", "Last", "Crash"},
 		strings.Fields(lines[0]))
 	assert.Equal(t,
-		[]string{"example-server", "grpc://localhost:4000", "stopped", "N/A", "N/A", "N/A", "N/A"},
+		[]string{"example-server", "grpc://localhost:4000", "stopped", "N/A", "N/A", "N/A", "N/A",
+			"0", "N/A"},
 		strings.Fields(lines[1]))
 	assert.Equal(t,
-		[]string{"user-server", "grpcs://localhost:4001", "stopped", "N/A", "N/A", "N/A", "N/A"},
+		[]string{"user-server", "grpcs://localhost:4001", "stopped", "N/A", "N/A", "N/A", "N/A",
+			"0", "N/A"},
 		strings.Fields(lines[2]))
 	assert.Equal(t,
-		[]string{"post-server", "grpcs://localhost:4002", "stopped", "N/A", "N/A", "N/A", "N/A"},
+		[]string{"post-server", "grpcs://localhost:4002", "stopped", "N/A", "N/A", "N/A", "N/A",
+			"0", "N/A"},
 		strings.Fields(lines[3]))
 }
@@ -0,0 +1,423 @@
+package pm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/util"
+)
+
+const (
+	defaultMaxLogFiles = 10
+	rotatedTimeFormat  = "20060102T150405.000"
+	// A line longer than this will be split, in case the output never ends with a newline.
+	maxLineSize = 64 * 1024
+)
+
+var logFiles = map[string]*logFile{}
+var logFilesLock sync.Mutex
+
+// logFile is a log file shared by all the writers that write to the same filename, it's rotated
+// when it's larger than the `maxSize` or older than the `maxAge`.
+type logFile struct {
+	filename string
+	maxSize  int64
+	maxAge   time.Duration
+	maxFiles int
+	compress bool
+	file     *os.File
+	size     int64
+	// The time when the file was created, the age of the file is counted from it.
+	createTime time.Time
+	// Whether the standard error of the process follows the file, see `RedirectStderr()`.
+	stderr bool
+	refs   int
+	lock   sync.Mutex
+}
+
+// openLogFile opens the log file, or references the one that has already been opened, in which
+// case the options of the first opener are used.
+func openLogFile(filename string, options config.LogOptions) (*logFile, error) {
+	filename = util.AbsPath(filename, false)
+
+	logFilesLock.Lock()
+	defer logFilesLock.Unlock()
+
+	if file, ok := logFiles[filename]; ok {
+		file.refs++
+		return file, nil
+	}
+
+	file := &logFile{
+		filename: filename,
+		maxSize:  int64(options.MaxSize) * 1024 * 1024,
+		maxAge:   time.Duration(options.MaxAge) * time.Hour,
+		maxFiles: options.MaxFiles,
+		compress: options.Compress,
+		refs:     1,
+	}
+
+	if file.maxFiles == 0 {
+		file.maxFiles = defaultMaxLogFiles
+	}
+
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+
+	logFiles[filename] = file
+	return file, nil
+}
+
+func (self *logFile) open() error {
+	file, err := os.OpenFile(self.filename, openForAppend, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	self.file = file
+	self.size = stat.Size()
+
+	if self.stderr {
+		redirectStderr(file)
+	}
+
+	if self.size > 0 {
+		self.createTime = getLogFileTime(self.filename)
+	} else {
+		self.createTime = time.Now()
+	}
+
+	return nil
+}
+
+func (self *logFile) Write(data []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if self.shouldRotate(len(data)) {
+		// If the file cannot be rotated, keep writing to the current one.
+		self.rotate()
+	}
+
+	n, err := self.file.Write(data)
+	self.size += int64(n)
+
+	return n, err
+}
+
+func (self *logFile) shouldRotate(size int) bool {
+	if self.size == 0 {
+		return false
+	} else if self.maxSize > 0 && self.size+int64(size) > self.maxSize {
+		return true
+	} else {
+		return self.maxAge > 0 && time.Since(self.createTime) >= self.maxAge
+	}
+}
+
+// rotate renames the current file to `<name>-<time><ext>` and opens a new one, the rotated file is
+// then compressed (if configured) and the old ones are removed in the background.
+func (self *logFile) rotate() error {
+	self.file.Close()
+
+	ext := filepath.Ext(self.filename)
+	rotated := strings.TrimSuffix(self.filename, ext) + "-" +
+		time.Now().Format(rotatedTimeFormat) + ext
+	renameErr := os.Rename(self.filename, rotated)
+
+	// The file must be reopened even if it cannot be renamed.
+	if err := self.open(); err != nil {
+		return err
+	} else if renameErr != nil {
+		return renameErr
+	}
+
+	go func() {
+		if self.compress {
+			compressFile(rotated)
+		}
+
+		self.prune()
+	}()
+
+	return nil
+}
+
+// prune removes the oldest rotated files that exceed the `maxFiles`.
+func (self *logFile) prune() {
+	if self.maxFiles < 0 {
+		return
+	}
+
+	files := listRotatedFiles(self.filename)
+
+	if len(files) > self.maxFiles {
+		for _, file := range files[:len(files)-self.maxFiles] {
+			os.Remove(file)
+		}
+	}
+}
+
+func (self *logFile) release() {
+	logFilesLock.Lock()
+	defer logFilesLock.Unlock()
+
+	self.refs--
+
+	if self.refs == 0 {
+		delete(logFiles, self.filename)
+
+		self.lock.Lock()
+		self.file.Close()
+		self.file = nil
+		self.lock.Unlock()
+	}
+}
+
+// listRotatedFiles returns the rotated files of the log file, sorted from the oldest to the newest.
+func listRotatedFiles(filename string) []string {
+	dir := filepath.Dir(filename)
+	ext := filepath.Ext(filename)
+	prefix := strings.TrimSuffix(filepath.Base(filename), ext) + "-"
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return []string{}
+	}
+
+	files := []string{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		stamp := strings.TrimPrefix(strings.TrimSuffix(name, ".gz"), prefix)
+
+		if !strings.HasSuffix(stamp, ext) {
+			continue
+		} else if _, err := time.Parse(rotatedTimeFormat, stamp[:len(stamp)-len(ext)]); err == nil {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+
+	// The time format is sortable, and a compressed file sorts the same as the original one.
+	slices.SortFunc(files, func(a string, b string) int {
+		return strings.Compare(strings.TrimSuffix(a, ".gz"), strings.TrimSuffix(b, ".gz"))
+	})
+
+	return files
+}
+
+// getLogFileTime returns the time when the existing log file was created, which is the time of the
+// last rotation, or the time of its first line if it has never been rotated.
+func getLogFileTime(filename string) time.Time {
+	if files := listRotatedFiles(filename); len(files) > 0 {
+		ext := filepath.Ext(filename)
+		prefix := strings.TrimSuffix(filepath.Base(filename), ext) + "-"
+		stamp := strings.TrimSuffix(filepath.Base(files[len(files)-1]), ".gz")
+		stamp = strings.TrimSuffix(strings.TrimPrefix(stamp, prefix), ext)
+
+		if t, err := time.ParseInLocation(rotatedTimeFormat, stamp, time.Local); err == nil {
+			return t
+		}
+	}
+
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+		line, _ := bufio.NewReader(io.LimitReader(file, maxLineSize)).ReadString('\n')
+
+		if stamp, _ := parseLogLine(line); !stamp.IsZero() {
+			return stamp
+		}
+	}
+
+	return time.Now()
+}
+
+func compressFile(filename string) error {
+	src, err := os.Open(filename)
+
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+	dst, err := os.Create(filename + ".gz")
+
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(dst)
+	_, err = io.Copy(writer, src)
+
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(filename + ".gz")
+		return err
+	}
+
+	src.Close()
+	return os.Remove(filename)
+}
+
+// LogWriter writes the output of an app to the log file line by line, so the lines from the apps
+// sharing the same file will not be mixed, and optionally prepends the timestamp and the app name
+// to each line.
+type LogWriter struct {
+	app       string
+	file      *logFile
+	timestamp bool
+	prefix    bool
+	buf       []byte
+	closed    bool
+	lock      sync.Mutex
+}
+
+// NewLogWriter opens the log file for the app, the file is rotated according to the `options`. The
+// writer must be closed once it's no longer used.
+func NewLogWriter(app string, filename string, options *config.LogOptions) (*LogWriter, error) {
+	if options == nil {
+		options = &config.LogOptions{}
+	}
+
+	file, err := openLogFile(filename, *options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogWriter{
+		app:       app,
+		file:      file,
+		timestamp: options.Timestamp,
+		prefix:    options.Prefix,
+		buf:       []byte{},
+	}, nil
+}
+
+func (self *LogWriter) Write(data []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return 0, os.ErrClosed
+	}
+
+	self.buf = append(self.buf, data...)
+	chunk := []byte{}
+
+	for {
+		idx := bytes.IndexByte(self.buf, '\n')
+
+		if idx == -1 && len(self.buf) >= maxLineSize {
+			idx = maxLineSize - 1
+		} else if idx == -1 {
+			break
+		}
+
+		chunk = append(chunk, self.formatLine(self.buf[:idx+1])...)
+		self.buf = self.buf[idx+1:]
+	}
+
+	if len(chunk) > 0 {
+		// Write the lines in one call, so they will not be interleaved with other writers'.
+		if _, err := self.file.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(data), nil
+}
+
+func (self *LogWriter) formatLine(line []byte) []byte {
+	if line[len(line)-1] != '\n' {
+		// Clip the slice so appending will not overwrite the rest of the buffer.
+		line = append(slices.Clip(line), '\n')
+	}
+
+	if !self.timestamp && !self.prefix {
+		return line
+	}
+
+	head := ""
+
+	if self.timestamp {
+		head += time.Now().Format(logTimeFormat) + " "
+	}
+
+	if self.prefix {
+		head += "[" + self.app + "] "
+	}
+
+	return append([]byte(head), line...)
+}
+
+// RedirectStderr points the standard error of the process to the log file, and keeps it pointing to
+// the current file after rotations, so the output that bypasses the writer, e.g. the runtime panics,
+// is kept in the log file as well.
+func (self *LogWriter) RedirectStderr() error {
+	self.file.lock.Lock()
+	defer self.file.lock.Unlock()
+
+	if self.file.file == nil {
+		return os.ErrClosed
+	}
+
+	self.file.stderr = true
+	return redirectStderr(self.file.file)
+}
+
+// Close flushes the unfinished line and releases the log file.
+func (self *LogWriter) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return nil
+	}
+
+	var err error
+
+	if len(self.buf) > 0 {
+		_, err = self.file.Write(self.formatLine(self.buf))
+		self.buf = nil
+	}
+
+	self.closed = true
+	self.file.release()
+
+	return err
+}
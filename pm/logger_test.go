@@ -0,0 +1,170 @@
+package pm
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogWriter(t *testing.T) {
+	defer os.Remove("test.log")
+
+	writer := goext.Ok(NewLogWriter("user-server", "test.log", &config.LogOptions{Prefix: true}))
+	writer.Write([]byte("hello"))
+	writer.Write([]byte(", world\nfoo"))
+
+	// The unfinished line is not written until it ends or the writer is closed.
+	assert.Equal(t, "[user-server] hello, world\n", string(goext.Ok(os.ReadFile("test.log"))))
+
+	writer.Close()
+	assert.Equal(t, "[user-server] hello, world\n[user-server] foo\n",
+		string(goext.Ok(os.ReadFile("test.log"))))
+
+	_, err := writer.Write([]byte("bar\n"))
+	assert.Equal(t, os.ErrClosed, err)
+}
+
+func TestLogWriter_timestamp(t *testing.T) {
+	defer os.Remove("test.log")
+
+	options := &config.LogOptions{Timestamp: true, Prefix: true}
+	writer := goext.Ok(NewLogWriter("user-server", "test.log", options))
+	writer.Write([]byte("hello, world\n"))
+	writer.Close()
+
+	line := string(goext.Ok(os.ReadFile("test.log")))
+	stamp := goext.Ok(time.ParseInLocation("2006/01/02 15:04:05", line[:19], time.Local))
+	assert.True(t, time.Since(stamp) < time.Minute)
+	assert.Equal(t, " [user-server] hello, world\n", line[19:])
+}
+
+func TestLogWriter_sharedFile(t *testing.T) {
+	defer os.Remove("test.log")
+
+	writer1 := goext.Ok(NewLogWriter("user-server", "test.log", nil))
+	writer2 := goext.Ok(NewLogWriter("post-server", "test.log", nil))
+	assert.Equal(t, writer1.file, writer2.file)
+	assert.Equal(t, 2, writer1.file.refs)
+
+	writer1.Write([]byte("user "))
+	writer2.Write([]byte("post server\n"))
+	writer1.Write([]byte("server\n"))
+
+	writer1.Close()
+	assert.Equal(t, 1, writer2.file.refs)
+	writer2.Close()
+
+	assert.Equal(t, "post server\nuser server\n", string(goext.Ok(os.ReadFile("test.log"))))
+
+	_, ok := logFiles[writer1.file.filename]
+	assert.False(t, ok)
+}
+
+func TestLogWriter_rotateBySize(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("logs", 0755))
+	defer os.RemoveAll("logs")
+
+	writer := goext.Ok(NewLogWriter("user-server", "logs/test.log", &config.LogOptions{
+		MaxFiles: 2,
+	}))
+	writer.file.maxSize = 20 // bytes, for testing
+
+	for i := 0; i < 5; i++ {
+		writer.Write([]byte("0123456789abcdef\n"))
+		time.Sleep(time.Millisecond * 5) // make sure the rotated files have different names
+	}
+
+	writer.Close()
+	time.Sleep(time.Millisecond * 50) // wait for pruning
+
+	files := listRotatedFiles(goext.Ok(filepath.Abs("logs/test.log")))
+	assert.Equal(t, 2, len(files))
+	assert.Equal(t, "0123456789abcdef\n", string(goext.Ok(os.ReadFile("logs/test.log"))))
+
+	for _, file := range files {
+		assert.Equal(t, "0123456789abcdef\n", string(goext.Ok(os.ReadFile(file))))
+	}
+}
+
+func TestLogWriter_rotateByAge(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("logs", 0755))
+	defer os.RemoveAll("logs")
+
+	writer := goext.Ok(NewLogWriter("user-server", "logs/test.log", &config.LogOptions{
+		Compress: true,
+	}))
+	writer.file.maxAge = time.Millisecond * 20 // for testing
+
+	writer.Write([]byte("first line\n"))
+	time.Sleep(time.Millisecond * 30)
+	writer.Write([]byte("second line\n"))
+	writer.Close()
+	time.Sleep(time.Millisecond * 50) // wait for compression
+
+	files := listRotatedFiles(goext.Ok(filepath.Abs("logs/test.log")))
+	assert.Equal(t, 1, len(files))
+	assert.True(t, strings.HasSuffix(files[0], ".log.gz"))
+	assert.Equal(t, "second line\n", string(goext.Ok(os.ReadFile("logs/test.log"))))
+
+	file := goext.Ok(os.Open(files[0]))
+	defer file.Close()
+	reader := goext.Ok(gzip.NewReader(file))
+	assert.Equal(t, "first line\n", string(goext.Ok(io.ReadAll(reader))))
+}
+
+func TestLogWriter_ageOfExistingFile(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("logs", 0755))
+	defer os.RemoveAll("logs")
+
+	// The age is counted from the last rotation instead of the time the file is opened.
+	rotatedAt := time.Now().Add(-time.Hour * 2)
+	writeLogs("logs/test-"+rotatedAt.Format(rotatedTimeFormat)+".log", "old line")
+	writeLogs("logs/test.log", "current line")
+
+	writer := goext.Ok(NewLogWriter("user-server", "logs/test.log", &config.LogOptions{MaxAge: 1}))
+	assert.Equal(t, rotatedAt.Truncate(time.Millisecond), writer.file.createTime)
+	writer.Write([]byte("new line\n"))
+	writer.Close()
+
+	assert.Equal(t, 2, len(listRotatedFiles(goext.Ok(filepath.Abs("logs/test.log")))))
+	assert.Equal(t, "new line\n", string(goext.Ok(os.ReadFile("logs/test.log"))))
+
+	// Without rotated files, the age is counted from the first line.
+	os.RemoveAll("logs")
+	goext.Ok(0, os.MkdirAll("logs", 0755))
+	writeLogs("logs/test.log", "2026/01/02 15:04:05 [user-server] first line", "second line")
+
+	writer = goext.Ok(NewLogWriter("user-server", "logs/test.log", &config.LogOptions{MaxAge: 1}))
+	assert.Equal(t, time.Date(2026, 1, 2, 15, 4, 5, 0, time.Local), writer.file.createTime)
+	writer.Close()
+}
+
+func TestListRotatedFiles(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("logs", 0755))
+	defer os.RemoveAll("logs")
+
+	for _, name := range []string{
+		"test.log",
+		"test-20260102T150405.000.log.gz",
+		"test-20260101T150405.000.log",
+		"test-old.log",
+		"test-20260103T150405.000.txt",
+		"other-20260101T150405.000.log",
+	} {
+		goext.Ok(0, os.WriteFile(filepath.Join("logs", name), []byte{}, 0644))
+	}
+
+	files := listRotatedFiles("logs/test.log")
+	assert.Equal(t, []string{
+		filepath.Join("logs", "test-20260101T150405.000.log"),
+		filepath.Join("logs", "test-20260102T150405.000.log.gz"),
+	}, files)
+}
@@ -0,0 +1,474 @@
+package pm
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/util"
+)
+
+const logTimeFormat = "2006/01/02 15:04:05"
+
+// LogsOptions is used to control which lines are printed by `PrintLogs`.
+type LogsOptions struct {
+	// The number of lines to print from the end of the logs, `0` or a negative value means all.
+	Lines int
+	// Only print the lines logged after this time.
+	Since time.Time
+	// Keep watching the log files and print the new lines until the context is canceled.
+	Follow bool
+}
+
+// logSource is a log file and the apps that write to it.
+type logSource struct {
+	filename string
+	// The selected apps that write to this file.
+	names []string
+	// The other apps that write to this file, their lines are filtered out.
+	others []string
+	// Whether some of the selected apps don't prefix their lines with the app name, in which case
+	// the lines without a known app name are considered to be theirs.
+	untagged bool
+	offset   int64
+	rest     string
+	// The time of the last line read, inherited by the following lines without a timestamp.
+	last time.Time
+}
+
+type logLine struct {
+	time time.Time
+	text string
+}
+
+// PrintLogs prints the logs of the app (or all apps if `appName` is empty) to the `out`. The log
+// files are found from the config file, including the rotated ones, and the lines of the files
+// are interleaved by their timestamps.
+func PrintLogs(ctx context.Context, out io.Writer, appName string, options LogsOptions) error {
+	cfg, err := config.LoadConfig()
+
+	if err != nil {
+		return err
+	}
+
+	return printLogs(ctx, out, cfg.Apps, appName, options)
+}
+
+func printLogs(
+	ctx context.Context,
+	out io.Writer,
+	apps []config.App,
+	appName string,
+	options LogsOptions,
+) error {
+	sources, err := findLogSources(apps, appName)
+
+	if err != nil {
+		return err
+	}
+
+	lines := []logLine{}
+
+	for _, source := range sources {
+		lines = mergeLogLines(lines, source.readAll(options))
+	}
+
+	if options.Lines > 0 && len(lines) > options.Lines {
+		lines = lines[len(lines)-options.Lines:]
+	}
+
+	for _, line := range lines {
+		fmt.Fprintln(out, line.text)
+	}
+
+	if !options.Follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(time.Millisecond * 200)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			lines := []logLine{}
+
+			for _, source := range sources {
+				lines = mergeLogLines(lines, source.readNew())
+			}
+
+			for _, line := range lines {
+				fmt.Fprintln(out, line.text)
+			}
+		}
+	}
+}
+
+func findLogSources(apps []config.App, appName string) ([]*logSource, error) {
+	sources := []*logSource{}
+	sourceMap := map[string]*logSource{}
+	found := false
+
+	for _, app := range apps {
+		selected := appName == "" || config.MatchApp(app.Name, appName)
+		found = found || selected
+
+		for _, filename := range []string{app.Stdout, app.Stderr} {
+			if filename == "" {
+				continue
+			}
+
+			filename = util.AbsPath(filename, false)
+			source, ok := sourceMap[filename]
+
+			if !ok {
+				source = &logSource{filename: filename, names: []string{}, others: []string{}}
+				sourceMap[filename] = source
+				sources = append(sources, source)
+			}
+
+			if !selected {
+				if !slices.Contains(source.others, app.Name) {
+					source.others = append(source.others, app.Name)
+				}
+			} else if !slices.Contains(source.names, app.Name) {
+				source.names = append(source.names, app.Name)
+				source.untagged = source.untagged || app.Log == nil || !app.Log.Prefix
+			}
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("app [%s] doesn't exist in the config file", appName)
+	}
+
+	sources = slices.DeleteFunc(sources, func(source *logSource) bool {
+		return len(source.names) == 0
+	})
+
+	if len(sources) == 0 {
+		if appName == "" {
+			return nil, fmt.Errorf("no log files are configured for the apps")
+		} else {
+			return nil, fmt.Errorf("no log files are configured for app [%s]", appName)
+		}
+	}
+
+	return sources, nil
+}
+
+// readAll reads the last lines of the current file and the rotated files, from the newest to the
+// oldest, until enough lines are collected or the lines are older than `options.Since`, and
+// remembers the offset of the current file for `readNew`.
+func (self *logSource) readAll(options LogsOptions) []logLine {
+	limit := options.Lines
+
+	if limit <= 0 {
+		limit = math.MaxInt
+	}
+
+	files := listRotatedFiles(self.filename)
+	chunks := [][]logLine{} // from the newest to the oldest
+	count := 0
+
+	for i := len(files); i >= 0 && count < limit; i-- {
+		var lines []logLine
+
+		if i == len(files) {
+			lines = self.tailCurrent(limit)
+		} else {
+			// The files rotated before the given time only contain older lines.
+			if stat, err := os.Stat(files[i]); err == nil &&
+				!options.Since.IsZero() && stat.ModTime().Before(options.Since) {
+				break
+			}
+
+			lines = self.tailFile(files[i], limit-count)
+		}
+
+		chunks = append(chunks, lines)
+		count += len(lines)
+
+		if !options.Since.IsZero() && slices.ContainsFunc(lines, func(line logLine) bool {
+			return !line.time.IsZero() && line.time.Before(options.Since)
+		}) {
+			break
+		}
+	}
+
+	slices.Reverse(chunks)
+	lines := fillLogTimes(slicex.Flat(chunks), time.Time{})
+
+	if len(lines) > 0 {
+		self.last = lines[len(lines)-1].time
+	}
+
+	if !options.Since.IsZero() {
+		lines = slices.DeleteFunc(lines, func(line logLine) bool {
+			return !line.time.IsZero() && line.time.Before(options.Since)
+		})
+	}
+
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+
+	return lines
+}
+
+// tailCurrent reads the last lines of the current file, and sets the offset for `readNew`, the
+// incomplete last line is kept as the `rest`.
+func (self *logSource) tailCurrent(n int) []logLine {
+	stat, err := os.Stat(self.filename)
+
+	if err != nil {
+		return []logLine{}
+	}
+
+	self.offset = stat.Size()
+	partial := false
+
+	if file, err := os.Open(self.filename); err == nil {
+		last := make([]byte, 1)
+
+		if _, err := file.ReadAt(last, stat.Size()-1); err == nil {
+			partial = last[0] != '\n'
+		}
+
+		file.Close()
+	}
+
+	return self.tailLines(self.filename, n, partial)
+}
+
+// tailFile reads the last lines of a rotated file, the compressed file has to be decompressed as a
+// whole, though.
+func (self *logSource) tailFile(filename string, n int) []logLine {
+	if !strings.HasSuffix(filename, ".gz") {
+		return self.tailLines(filename, n, false)
+	}
+
+	text, err := readLogFile(filename)
+
+	if err != nil {
+		return []logLine{}
+	}
+
+	lines := self.filterLines(strings.Split(strings.TrimRight(text, "\r\n"), "\n"))
+	return lines[max(len(lines)-n, 0):]
+}
+
+// tailLines reads the last `n` lines of the file that belong to the selected apps, more lines are
+// read if some of them are filtered out, until the file is exhausted. If `partial` is set, the last
+// line is incomplete and kept as the `rest`.
+func (self *logSource) tailLines(filename string, n int, partial bool) []logLine {
+	for size := n; ; size *= 2 {
+		texts, err := util.TailFile(filename, size)
+
+		if err != nil {
+			return []logLine{}
+		}
+
+		exhausted := len(texts) < size
+
+		if partial && len(texts) > 0 {
+			self.rest = texts[len(texts)-1]
+			texts = texts[:len(texts)-1]
+		}
+
+		lines := self.filterLines(texts)
+
+		if len(lines) >= n || exhausted || size > math.MaxInt/2 {
+			return lines[max(len(lines)-n, 0):]
+		}
+	}
+}
+
+// readNew reads the lines that are appended to the current file since the last read.
+func (self *logSource) readNew() []logLine {
+	file, err := os.Open(self.filename)
+
+	if err != nil {
+		return []logLine{}
+	}
+
+	defer file.Close()
+	stat, err := file.Stat()
+
+	if err != nil {
+		return []logLine{}
+	} else if stat.Size() < self.offset {
+		// The file has been rotated or truncated, read it from the beginning.
+		self.offset = 0
+		self.rest = ""
+	}
+
+	data := make([]byte, stat.Size()-self.offset)
+	n, _ := file.ReadAt(data, self.offset)
+	self.offset += int64(n)
+
+	return self.parseLines(string(data[:n]))
+}
+
+// parseLines splits the text into lines and filters out those that belong to other apps, the
+// incomplete last line is kept as the `rest` for the next read.
+func (self *logSource) parseLines(text string) []logLine {
+	text = self.rest + text
+	self.rest = ""
+
+	if idx := strings.LastIndexByte(text, '\n'); idx == -1 {
+		self.rest = text
+		return []logLine{}
+	} else {
+		self.rest = text[idx+1:]
+		text = text[:idx]
+	}
+
+	fallback := self.last
+
+	if fallback.IsZero() {
+		fallback = time.Now()
+	}
+
+	lines := fillLogTimes(self.filterLines(strings.Split(text, "\n")), fallback)
+
+	if len(lines) > 0 {
+		self.last = lines[len(lines)-1].time
+	}
+
+	return lines
+}
+
+// filterLines parses the lines and filters out those that belong to other apps, the lines without
+// a timestamp are left with a zero time, see `fillLogTimes()`.
+func (self *logSource) filterLines(texts []string) []logLine {
+	lines := []logLine{}
+
+	for _, text := range texts {
+		text = strings.TrimRight(text, "\r")
+		stamp, app := parseLogLine(text)
+
+		if len(self.others) > 0 {
+			if slices.Contains(self.others, app) {
+				continue
+			} else if !slices.Contains(self.names, app) && !self.untagged {
+				continue
+			}
+		}
+
+		lines = append(lines, logLine{time: stamp, text: text})
+	}
+
+	return lines
+}
+
+// fillLogTimes gives the lines without a timestamp the time of the previous line, so they stay
+// with their neighbours when merged. The leading ones use the `fallback` time, or the time of the
+// next line if `fallback` is zero.
+func fillLogTimes(lines []logLine, fallback time.Time) []logLine {
+	last := fallback
+
+	if last.IsZero() {
+		if idx := slices.IndexFunc(lines, func(line logLine) bool {
+			return !line.time.IsZero()
+		}); idx != -1 {
+			last = lines[idx].time
+		}
+	}
+
+	for i := range lines {
+		if lines[i].time.IsZero() {
+			lines[i].time = last
+		} else {
+			last = lines[i].time
+		}
+	}
+
+	return lines
+}
+
+// parseLogLine parses the timestamp and the app name written by the `LogWriter`, the timestamp may
+// also be written by the app itself after the app name.
+func parseLogLine(line string) (stamp time.Time, app string) {
+	parseTime := func(str string) time.Time {
+		if len(str) < len(logTimeFormat) {
+			return time.Time{}
+		}
+
+		t, _ := time.ParseInLocation(logTimeFormat, str[:len(logTimeFormat)], time.Local)
+		return t
+	}
+
+	if stamp = parseTime(line); !stamp.IsZero() {
+		line = strings.TrimPrefix(line[len(logTimeFormat):], " ")
+	}
+
+	if strings.HasPrefix(line, "[") {
+		if end := strings.Index(line, "] "); end > 0 {
+			app = line[1:end]
+			line = line[end+2:]
+		}
+	}
+
+	if stamp.IsZero() {
+		stamp = parseTime(line)
+	}
+
+	return stamp, app
+}
+
+// mergeLogLines merges two lists of lines that are sorted by time, the lines with the same time
+// keep their original order.
+func mergeLogLines(a []logLine, b []logLine) []logLine {
+	result := make([]logLine, 0, len(a)+len(b))
+	i, j := 0, 0
+
+	for i < len(a) && j < len(b) {
+		if b[j].time.Before(a[i].time) {
+			result = append(result, b[j])
+			j++
+		} else {
+			result = append(result, a[i])
+			i++
+		}
+	}
+
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+func readLogFile(filename string) (string, error) {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+	var reader io.Reader = bufio.NewReader(file)
+
+	if strings.HasSuffix(filename, ".gz") {
+		gz, err := gzip.NewReader(reader)
+
+		if err != nil {
+			return "", err
+		}
+
+		defer gz.Close()
+		reader = gz
+	}
+
+	data, err := io.ReadAll(reader)
+	return string(data), err
+}
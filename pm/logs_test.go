@@ -0,0 +1,208 @@
+package pm
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type syncBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (self *syncBuffer) Write(data []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.buf.Write(data)
+}
+
+func (self *syncBuffer) String() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.buf.String()
+}
+
+func writeLogs(filename string, lines ...string) {
+	goext.Ok(0, os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644))
+}
+
+func getLogsTestApps() []config.App {
+	options := &config.LogOptions{Timestamp: true, Prefix: true}
+
+	return []config.App{
+		{Name: "user-server#0", Stdout: "logs/out.log", Log: options},
+		{Name: "user-server#1", Stdout: "logs/out.log", Log: options},
+		{Name: "post-server", Stdout: "logs/out.log", Log: options},
+		{Name: "job-server", Stdout: "logs/job.log", Stderr: "logs/job.err.log"},
+		{Name: "remote-server"},
+	}
+}
+
+func TestPrintLogs(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("logs", 0755))
+	defer os.RemoveAll("logs")
+
+	writeLogs("logs/out-20260101T000000.000.log",
+		"2026/01/01 00:00:00 [user-server#0] rotated line")
+	goext.Ok(0, compressFile("logs/out-20260101T000000.000.log"))
+	writeLogs("logs/out.log",
+		"2026/01/02 00:00:00 [user-server#0] line 1",
+		"2026/01/02 00:00:02 [post-server] line 2",
+		"2026/01/02 00:00:03 [user-server#1] line 3",
+	)
+	writeLogs("logs/job.log",
+		"2026/01/02 00:00:01 job line 1",
+		"    at main.go:10",
+		"2026/01/02 00:00:04 job line 2",
+	)
+	writeLogs("logs/job.err.log", "2026/01/02 00:00:02 job error")
+
+	apps := getLogsTestApps()
+	out := &bytes.Buffer{}
+	goext.Ok(0, printLogs(context.Background(), out, apps, "", LogsOptions{}))
+	assert.Equal(t, strings.Join([]string{
+		"2026/01/01 00:00:00 [user-server#0] rotated line",
+		"2026/01/02 00:00:00 [user-server#0] line 1",
+		"2026/01/02 00:00:01 job line 1",
+		"    at main.go:10",
+		"2026/01/02 00:00:02 [post-server] line 2",
+		"2026/01/02 00:00:02 job error",
+		"2026/01/02 00:00:03 [user-server#1] line 3",
+		"2026/01/02 00:00:04 job line 2",
+	}, "\n")+"\n", out.String())
+
+	out.Reset()
+	goext.Ok(0, printLogs(context.Background(), out, apps, "user-server", LogsOptions{Lines: 3}))
+	assert.Equal(t, strings.Join([]string{
+		"2026/01/01 00:00:00 [user-server#0] rotated line",
+		"2026/01/02 00:00:00 [user-server#0] line 1",
+		"2026/01/02 00:00:03 [user-server#1] line 3",
+	}, "\n")+"\n", out.String())
+
+	out.Reset()
+	goext.Ok(0, printLogs(context.Background(), out, apps, "post-server", LogsOptions{}))
+	assert.Equal(t, "2026/01/02 00:00:02 [post-server] line 2\n", out.String())
+
+	out.Reset()
+	since := time.Date(2026, 1, 2, 0, 0, 2, 0, time.Local)
+	goext.Ok(0, printLogs(context.Background(), out, apps, "job-server", LogsOptions{Since: since}))
+	assert.Equal(t, strings.Join([]string{
+		"2026/01/02 00:00:02 job error",
+		"2026/01/02 00:00:04 job line 2",
+	}, "\n")+"\n", out.String())
+}
+
+func TestPrintLogs_tail(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("logs", 0755))
+	defer os.RemoveAll("logs")
+
+	writeLogs("logs/job-20260101T000000.000.log",
+		"2026/01/01 00:00:00 job line 1",
+		"2026/01/01 00:00:05 job line 2",
+	)
+	goext.Ok(0, compressFile("logs/job-20260101T000000.000.log"))
+	writeLogs("logs/job-20260102T000000.000.log",
+		"2026/01/02 00:00:00 job line 3",
+		"2026/01/02 00:00:03 job line 4",
+	)
+	// The stack trace continues the last line of the previous file.
+	writeLogs("logs/job.log",
+		"    at main.go:10",
+		"2026/01/03 00:00:00 job line 5",
+	)
+	writeLogs("logs/job.err.log", "2026/01/02 00:00:04 job error")
+
+	apps := getLogsTestApps()
+	out := &bytes.Buffer{}
+	goext.Ok(0, printLogs(context.Background(), out, apps, "job-server", LogsOptions{Lines: 4}))
+	assert.Equal(t, strings.Join([]string{
+		"2026/01/02 00:00:03 job line 4",
+		"    at main.go:10",
+		"2026/01/02 00:00:04 job error",
+		"2026/01/03 00:00:00 job line 5",
+	}, "\n")+"\n", out.String())
+
+	out.Reset()
+	goext.Ok(0, printLogs(context.Background(), out, apps, "job-server", LogsOptions{Lines: 10}))
+	assert.Equal(t, strings.Join([]string{
+		"2026/01/01 00:00:00 job line 1",
+		"2026/01/01 00:00:05 job line 2",
+		"2026/01/02 00:00:00 job line 3",
+		"2026/01/02 00:00:03 job line 4",
+		"    at main.go:10",
+		"2026/01/02 00:00:04 job error",
+		"2026/01/03 00:00:00 job line 5",
+	}, "\n")+"\n", out.String())
+}
+
+func TestPrintLogs_error(t *testing.T) {
+	apps := getLogsTestApps()
+
+	err := printLogs(context.Background(), &bytes.Buffer{}, apps, "test-server", LogsOptions{})
+	assert.Equal(t, "app [test-server] doesn't exist in the config file", err.Error())
+
+	err = printLogs(context.Background(), &bytes.Buffer{}, apps, "remote-server", LogsOptions{})
+	assert.Equal(t, "no log files are configured for app [remote-server]", err.Error())
+}
+
+func TestPrintLogs_follow(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("logs", 0755))
+	defer os.RemoveAll("logs")
+
+	writeLogs("logs/out.log", "2026/01/02 00:00:00 [post-server] line 1")
+
+	apps := getLogsTestApps()
+	out := &syncBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- printLogs(ctx, out, apps, "post-server", LogsOptions{Follow: true})
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, "2026/01/02 00:00:00 [post-server] line 1\n", out.String())
+
+	writer := goext.Ok(NewLogWriter("post-server", "logs/out.log", apps[2].Log))
+	other := goext.Ok(NewLogWriter("user-server#0", "logs/out.log", apps[0].Log))
+	writer.Write([]byte("line 2\nline "))
+	other.Write([]byte("hello\n"))
+	time.Sleep(time.Millisecond * 300)
+	writer.Write([]byte("3\n"))
+	writer.Close()
+	other.Close()
+	time.Sleep(time.Millisecond * 300)
+
+	cancel()
+	assert.Nil(t, <-done)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.True(t, strings.HasSuffix(lines[1], " [post-server] line 2"))
+	assert.True(t, strings.HasSuffix(lines[2], " [post-server] line 3"))
+}
+
+func TestParseLogLine(t *testing.T) {
+	stamp := time.Date(2026, 1, 2, 15, 4, 5, 0, time.Local)
+
+	t1, app1 := parseLogLine("2026/01/02 15:04:05 [user-server] hello")
+	assert.Equal(t, stamp, t1)
+	assert.Equal(t, "user-server", app1)
+
+	t2, app2 := parseLogLine("[user-server] 2026/01/02 15:04:05 hello")
+	assert.Equal(t, stamp, t2)
+	assert.Equal(t, "user-server", app2)
+
+	t3, app3 := parseLogLine("hello, world")
+	assert.True(t, t3.IsZero())
+	assert.Equal(t, "", app3)
+}
@@ -0,0 +1,417 @@
+package pm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// How often the host server checks the apps' cron schedules, and how often it samples the memory
+// usage of the apps. They're variables so the tests can shorten them.
+var monitorInterval = time.Second
+var memorySampleInterval = time.Second * 10
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultHealthCheckRetries  = 3
+)
+
+// appMonitor keeps track of the memory samples, the restart schedule and the health probes of an
+// app instance.
+type appMonitor struct {
+	// The pid of the instance being monitored, the state of the probes is reset once it changes.
+	pid        int
+	cronExpr   string
+	cron       *util.CronSchedule
+	nextCron   time.Time
+	lastSample time.Time
+	lastProbe  time.Time
+	probing    bool
+	failures   int
+	// The pid of the instance that is being restarted by the monitor, so it will not be restarted
+	// again before it exits.
+	restarting int
+}
+
+// check reports the reason why the app instance should be restarted, or an empty string if it
+// shouldn't.
+func (self *appMonitor) check(app config.App, client clientRecord, now time.Time) string {
+	if self.restarting == client.Pid {
+		return ""
+	} else if self.pid != client.Pid {
+		self.pid = client.Pid
+		self.lastProbe = time.Time{}
+		self.failures = 0
+	}
+
+	if app.RestartCron != self.cronExpr {
+		self.cronExpr = app.RestartCron
+		self.cron, _ = util.ParseCron(app.RestartCron)
+		self.nextCron = time.Time{}
+
+		if self.cron != nil {
+			self.nextCron = self.cron.Next(now)
+		}
+	}
+
+	if self.cron != nil && !self.nextCron.IsZero() && !now.Before(self.nextCron) {
+		// If the app was not running at the scheduled time, skip to the next one instead of
+		// restarting it right after it comes online.
+		missed := now.Sub(self.nextCron) >= time.Minute
+		self.nextCron = self.cron.Next(now)
+
+		if !missed {
+			return fmt.Sprintf("reached the restart schedule (%s)", app.RestartCron)
+		}
+	}
+
+	if app.MaxMemory > 0 && now.Sub(self.lastSample) >= memorySampleInterval {
+		self.lastSample = now
+		stat, err := util.GetPidStat(client.Pid)
+
+		if err == nil && stat.Memory > float64(app.MaxMemory)*1024*1024 {
+			return fmt.Sprintf("exceeded the memory limit (%.2f Mb > %d Mb)",
+				stat.Memory/1024/1024, app.MaxMemory)
+		}
+	}
+
+	return ""
+}
+
+// shouldProbe reports whether it's time to probe the app instance, and if so, marks the monitor as
+// probing, so there will be only one probe at a time.
+func (self *appMonitor) shouldProbe(app config.App, client clientRecord, now time.Time) bool {
+	interval, _, _ := getHealthCheckOptions(app)
+
+	// A drained app reports NOT_SERVING on purpose, don't restart it.
+	if !app.Serve || interval < 0 || client.Drained || self.probing ||
+		self.restarting == client.Pid {
+		return false
+	} else if now.Sub(self.lastProbe) < interval {
+		return false
+	}
+
+	self.probing = true
+	self.lastProbe = now
+	return true
+}
+
+func getHealthCheckOptions(app config.App) (
+	interval time.Duration,
+	timeout time.Duration,
+	retries int,
+) {
+	interval = defaultHealthCheckInterval
+	timeout = defaultHealthCheckTimeout
+	retries = defaultHealthCheckRetries
+
+	if options := app.HealthCheck; options != nil {
+		if options.Interval != 0 {
+			interval = time.Duration(options.Interval) * time.Millisecond
+		}
+
+		if options.Timeout > 0 {
+			timeout = time.Duration(options.Timeout) * time.Millisecond
+		}
+
+		if options.Retries > 0 {
+			retries = options.Retries
+		}
+	}
+
+	return interval, timeout, retries
+}
+
+// monitorApps checks the apps periodically and restarts those that exceed their `maxMemory`, reach
+// their `restartCron` schedule, or fail the health checks.
+func (self *Host) monitorApps() {
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if self.state.Load() != 1 {
+			break
+		}
+
+		self.checkApps(now)
+	}
+}
+
+func (self *Host) checkApps(now time.Time) {
+	clients := self.filterClients(func(item clientRecord) bool {
+		return item.Ready && item.Pid > 0 && item.App != "" && item.App != ":cli"
+	})
+
+	// Use the latest config, the same as the restarts do, so the changes take effect without
+	// restarting the host server.
+	apps := self.getApps()
+
+	for _, client := range clients {
+		app, ok := slicex.Find(apps, func(item config.App, _ int) bool {
+			return item.Name == client.App
+		})
+
+		if !ok {
+			continue
+		}
+
+		self.monitorsLock.Lock()
+		monitor, ok := self.monitors[app.Name]
+
+		if !ok {
+			monitor = &appMonitor{}
+			self.monitors[app.Name] = monitor
+		}
+
+		reason := monitor.check(app, client, now)
+		probe := false
+
+		if reason != "" {
+			monitor.restarting = client.Pid
+		} else {
+			probe = monitor.shouldProbe(app, client, now)
+		}
+
+		self.monitorsLock.Unlock()
+
+		if reason != "" {
+			go self.restartApp(client, reason, false)
+		} else if probe {
+			go self.probeApp(app, client, monitor)
+		}
+	}
+}
+
+// probeApp checks the health of the app instance, once it fails for the given times in a row, the
+// app is marked as unhealthy and restarted.
+func (self *Host) probeApp(app config.App, client clientRecord, monitor *appMonitor) {
+	_, timeout, retries := getHealthCheckOptions(app)
+	err := self.pingClient(client, timeout)
+
+	if err == nil {
+		err = checkAppHealth(app, client.Url, timeout)
+	}
+
+	self.monitorsLock.Lock()
+	monitor.probing = false
+
+	if monitor.pid != client.Pid { // the instance has been replaced
+		self.monitorsLock.Unlock()
+		return
+	} else if err == nil {
+		monitor.failures = 0
+		self.monitorsLock.Unlock()
+		return
+	}
+
+	monitor.failures++
+	failures := monitor.failures
+	unhealthy := failures >= retries && monitor.restarting != client.Pid
+
+	if unhealthy {
+		monitor.restarting = client.Pid
+	}
+
+	self.monitorsLock.Unlock()
+	self.logApp(app, "app [%v] failed the health check (%d/%d): %v",
+		app.Name, failures, retries, err)
+
+	if unhealthy {
+		reason := fmt.Sprintf("is unhealthy after %d failed health checks", failures)
+		self.markClientUnhealthy(client.conn)
+		self.publishEvent(EventUnhealthy, app.Name, client.Pid, reason)
+		self.restartApp(client, reason, false)
+	}
+}
+
+// handleHealth checks the health of the app instance on demand and replies the result, it's used by
+// the CLI for the rolling restart.
+func (self *Host) handleHealth(conn net.Conn, msg ControlMessage) {
+	reply := newReply(msg)
+	client, exists := self.findClient(func(item clientRecord) bool {
+		return item.App == msg.App && item.Ready
+	})
+
+	if !exists {
+		reply = newErrorReply(msg, newProtocolError(ErrAppNotRunning,
+			"app [%s] is not running", msg.App))
+	} else {
+		app, ok := self.findAppConfig(msg.App)
+
+		if !ok {
+			app = config.App{Name: msg.App}
+		}
+
+		interval, timeout, _ := getHealthCheckOptions(app)
+		err := self.pingClient(client, timeout)
+
+		if err == nil && app.Serve && interval >= 0 {
+			err = checkAppHealth(app, client.Url, timeout)
+		}
+
+		if err != nil {
+			reply = newErrorReply(msg, newProtocolError(ErrUnhealthy,
+				"app [%s] is unhealthy: %v", msg.App, err))
+		}
+	}
+
+	conn.Write(EncodeMessage(reply))
+}
+
+// pingClient sends a `ping` to the guest via the control channel and waits for the `pong`.
+func (self *Host) pingClient(client clientRecord, timeout time.Duration) error {
+	pong := make(chan ControlMessage, 1)
+	msgId := self.sendRequest(client, ControlMessage{Cmd: CmdPing}, func(reply ControlMessage) {
+		pong <- reply
+	})
+
+	select {
+	case reply := <-pong:
+		return reply.Err() // the guest may have disconnected
+	case <-time.After(timeout):
+		self.callbacks.Delete(msgId)
+		return fmt.Errorf("no pong within %v", timeout)
+	}
+}
+
+// checkAppHealth calls the standard gRPC health service of the app with the app's credentials. If
+// the app doesn't implement the health service (e.g. a Node.js app), the server being able to
+// respond is considered healthy.
+func checkAppHealth(app config.App, rawUrl string, timeout time.Duration) error {
+	if rawUrl == "" {
+		rawUrl = app.Url
+	}
+
+	urlObj, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return err
+	} else if urlObj.Scheme == "xds" {
+		return nil // the app is not served by us
+	}
+
+	cred, err := config.GetCredentials(app, urlObj)
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := grpc.Dial(config.GetAddress(urlObj), grpc.WithTransportCredentials(cred))
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	} else if err != nil {
+		return err
+	} else if res.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("the gRPC health status is %v", res.Status)
+	}
+
+	return nil
+}
+
+// restartApp gracefully stops the app instance and spawns it again, the reason of the restart is
+// written to the app's log file. If `force` is set, the app is killed without being asked to stop,
+// e.g. it's unresponsive.
+func (self *Host) restartApp(client clientRecord, reason string, force bool) {
+	app, ok := self.findAppConfig(client.App)
+
+	if !ok {
+		return
+	}
+
+	// If the process is supervised by the host, it's respawned once it exits.
+	supervised := self.markRespawn(app.Name, client.Pid, reason)
+
+	if force {
+		self.logApp(app, "app [%v] %s, killing it...", app.Name, reason)
+
+		if !self.killClient(app, client, "killed") {
+			return
+		}
+	} else {
+		self.logApp(app, "app [%v] %s, restarting...", app.Name, reason)
+		replyChan := make(chan ControlMessage, 1)
+		msgId := self.sendRequest(client, ControlMessage{Cmd: CmdStop}, func(reply ControlMessage) {
+			replyChan <- reply
+		})
+
+		select {
+		case reply := <-replyChan:
+			// The app may exit without replying, which is fine.
+			if reply.Error != "" && reply.Code != ErrAppNotRunning {
+				self.logApp(app, "app [%v] failed to stop: %s", app.Name, reply.Error)
+				return
+			}
+		case <-time.After(defaultStopTimeout):
+			self.callbacks.Delete(msgId)
+			self.logApp(app, "app [%v] didn't stop within %v, killing it...",
+				app.Name, defaultStopTimeout)
+
+			if !self.killClient(app, client,
+				fmt.Sprintf("didn't stop within %v, killed", defaultStopTimeout)) {
+				return
+			}
+		}
+	}
+
+	if !supervised && self.state.Load() == 1 {
+		if pid, err := self.spawnApp(app); err != nil {
+			self.logApp(app, "unable to restart app [%v]: %v", app.Name, err)
+		} else {
+			self.publishEvent(EventRestarted, app.Name, pid, reason)
+		}
+	}
+}
+
+// killClient kills the process of the app instance, the client is removed first, so the
+// disconnection will not be treated as a crash. It reports whether the process is killed.
+func (self *Host) killClient(app config.App, client clientRecord, reason string) bool {
+	self.removeClient(func(item clientRecord) bool {
+		return item.conn == client.conn
+	})
+	self.notifyWatchers(app.Name)
+	self.publishEvent(EventOffline, app.Name, client.Pid, reason)
+
+	if err := killProcess(client.Pid); err != nil {
+		self.logApp(app, "unable to kill app [%v]: %v", app.Name, err)
+		return false
+	}
+
+	return true
+}
+
+func killProcess(pid int) error {
+	if pid == os.Getpid() {
+		return fmt.Errorf("process %d is the host server itself", pid)
+	}
+
+	proc, err := os.FindProcess(pid)
+
+	if err != nil {
+		return err
+	}
+
+	return proc.Kill()
+}
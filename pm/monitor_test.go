@@ -0,0 +1,184 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestAppMonitor_cron(t *testing.T) {
+	app := config.App{Name: "cron-app", RestartCron: "0 3 * * *"}
+	client := clientRecord{App: "cron-app", Pid: os.Getpid()}
+	monitor := &appMonitor{}
+	start := time.Date(2026, 1, 2, 2, 59, 0, 0, time.Local)
+
+	assert.Equal(t, "", monitor.check(app, client, start))
+	assert.Equal(t, time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local), monitor.nextCron)
+
+	reason := monitor.check(app, client, start.Add(time.Minute+time.Second))
+	assert.Equal(t, "reached the restart schedule (0 3 * * *)", reason)
+	assert.Equal(t, time.Date(2026, 1, 3, 3, 0, 0, 0, time.Local), monitor.nextCron)
+
+	// A missed schedule doesn't trigger a restart.
+	next := time.Date(2026, 1, 3, 3, 5, 0, 0, time.Local)
+	assert.Equal(t, "", monitor.check(app, client, next))
+	assert.Equal(t, time.Date(2026, 1, 4, 3, 0, 0, 0, time.Local), monitor.nextCron)
+
+	// The instance being restarted is skipped.
+	monitor.restarting = client.Pid
+	assert.Equal(t, "", monitor.check(app, client, time.Date(2026, 1, 4, 3, 0, 0, 0, time.Local)))
+}
+
+func TestHost_restartOnMaxMemory(t *testing.T) {
+	conf := `{"apps":[{"name":"mem-app","url":"grpc://localhost:4012","serve":true,` +
+		`"entry":"sleep.sh","stdout":"mem.log"}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	goext.Ok(0, os.WriteFile("sleep.sh", []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("sleep.sh")
+	defer os.Remove("mem.log")
+
+	interval, sampleInterval := monitorInterval, memorySampleInterval
+	monitorInterval, memorySampleInterval = time.Millisecond*50, 0
+	defer func() {
+		monitorInterval, memorySampleInterval = interval, sampleInterval
+	}()
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	// The limit added after the host server starts takes effect.
+	conf = strings.Replace(conf, `"stdout":"mem.log"`, `"stdout":"mem.log","maxMemory":1`, 1)
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+
+	// The guest runs in the test process, which uses more than 1 Mb memory.
+	stopped := make(chan string, 1)
+	var guest *Guest
+	guest = NewGuest(config.App{Name: "mem-app", Url: "grpc://localhost:4012"}, func(msgId string) {
+		guest.Leave("app [mem-app] stopped", msgId)
+		stopped <- msgId
+	})
+	guest.Join()
+	guest.Ready()
+
+	select {
+	case msgId := <-stopped:
+		assert.NotEqual(t, "", msgId)
+	case <-time.After(time.Second):
+		t.Fatal("the app was not stopped")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	assert.True(t, host.isSupervised("mem-app"))
+
+	log := string(goext.Ok(os.ReadFile("mem.log")))
+	assert.Regexp(t,
+		`app \[mem-app\] exceeded the memory limit \(\d+\.\d+ Mb > 1 Mb\), restarting\.\.\.`, log)
+
+	host.processesLock.Lock()
+	record := host.processes["mem-app"]
+	host.processesLock.Unlock()
+	host.markStopping("mem-app")
+	record.cmd.Process.Kill()
+	time.Sleep(time.Millisecond * 100) // wait for the exit to be logged
+}
+
+func TestCheckAppHealth(t *testing.T) {
+	listener := goext.Ok(net.Listen("tcp", "localhost:0"))
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	app := config.App{Name: "health-app", Url: "grpc://" + listener.Addr().String()}
+	assert.Nil(t, checkAppHealth(app, "", time.Second))
+
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	err := checkAppHealth(app, "", time.Second)
+	assert.EqualError(t, err, "the gRPC health status is NOT_SERVING")
+
+	// The server doesn't implement the health service.
+	listener2 := goext.Ok(net.Listen("tcp", "localhost:0"))
+	server2 := grpc.NewServer()
+	go server2.Serve(listener2)
+	defer server2.Stop()
+	assert.Nil(t, checkAppHealth(app, "grpc://"+listener2.Addr().String(), time.Second))
+
+	// The server is down.
+	addr := listener2.Addr().String()
+	server2.Stop()
+	assert.NotNil(t, checkAppHealth(app, "grpc://"+addr, time.Millisecond*100))
+}
+
+func TestHost_restartOnUnhealthy(t *testing.T) {
+	// Port 4013 is not served, so the gRPC health check fails while the ping succeeds.
+	conf := `{"apps":[{"name":"sick-app","url":"grpc://localhost:4013","serve":true,` +
+		`"entry":"sleep.sh","stdout":"sick.log",` +
+		`"healthCheck":{"interval":50,"timeout":100,"retries":2}}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	goext.Ok(0, os.WriteFile("sleep.sh", []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("sleep.sh")
+	defer os.Remove("sick.log")
+
+	interval := monitorInterval
+	monitorInterval = time.Millisecond * 20
+	defer func() {
+		monitorInterval = interval
+	}()
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	stopped := make(chan string, 1)
+	var guest *Guest
+	guest = NewGuest(config.App{Name: "sick-app", Url: "grpc://localhost:4013"}, func(msgId string) {
+		client, _ := host.findClient(func(item clientRecord) bool {
+			return item.App == "sick-app"
+		})
+		assert.True(t, client.Unhealthy)
+
+		guest.Leave("app [sick-app] stopped", msgId)
+		stopped <- msgId
+	})
+	guest.Join()
+	guest.Ready()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 2):
+		t.Fatal("the app was not stopped")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	assert.True(t, host.isSupervised("sick-app"))
+
+	log := string(goext.Ok(os.ReadFile("sick.log")))
+	assert.Contains(t, log, "app [sick-app] failed the health check (1/2): ")
+	assert.Contains(t, log, "app [sick-app] failed the health check (2/2): ")
+	assert.Contains(t, log,
+		"app [sick-app] is unhealthy after 2 failed health checks, restarting...")
+	assert.NotContains(t, log, "no pong")
+
+	host.processesLock.Lock()
+	record := host.processes["sick-app"]
+	host.processesLock.Unlock()
+	host.markStopping("sick-app")
+	record.cmd.Process.Kill()
+	time.Sleep(time.Millisecond * 100) // wait for the exit to be logged
+}
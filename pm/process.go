@@ -0,0 +1,280 @@
+package pm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/util"
+)
+
+// processRecord holds the child process of an app spawned by the host server.
+type processRecord struct {
+	app config.App
+	cmd *exec.Cmd
+	// The app has sent `goodbye` to the host, so its exit is expected.
+	stopping bool
+	// The app is being restarted by the host, so it should be spawned again once it exits.
+	respawn bool
+	// Why the app is being restarted, published along with the `restarted` event.
+	respawnReason string
+}
+
+// spawnApp starts the app and supervises its process, once the process exits, the host records the
+// exit code and signal, and restarts the app according to its restart policy.
+func (self *Host) spawnApp(app config.App) (int, error) {
+	stdout, stderr, err := openAppLogs(app)
+
+	if err != nil {
+		return 0, err
+	}
+
+	binary, err := self.resolveGoBinary(app)
+
+	if err != nil {
+		closeAppLogs(stdout, stderr)
+		return 0, err
+	}
+
+	cmd, err := startProcess(app, self.tsCfg, binary, stdout, stderr)
+
+	if err != nil {
+		closeAppLogs(stdout, stderr)
+		return 0, err
+	}
+
+	record := &processRecord{app: app, cmd: cmd}
+
+	self.processesLock.Lock()
+	self.processes[app.Name] = record
+	self.processesLock.Unlock()
+
+	go self.waitProcess(record)
+
+	return cmd.Process.Pid, nil
+}
+
+// resolveGoBinary returns the binary of the Go app (or an empty string for other apps), the entry
+// is only built if it hasn't been built by the host server or sent by the CLI, so the revives and
+// restarts don't hash the sources again, they run the binary last built by `start` or `restart`.
+func (self *Host) resolveGoBinary(app config.App) (string, error) {
+	if filepath.Ext(app.Entry) != ".go" {
+		return "", nil
+	} else if binary, ok := self.binaries.Get(app.Entry); ok && util.Exists(binary) {
+		return binary, nil
+	}
+
+	binary, err := BuildGoEntry(app.Entry, false)
+
+	if err != nil {
+		return "", err
+	}
+
+	self.binaries.Set(app.Entry, binary)
+	return binary, nil
+}
+
+func (self *Host) waitProcess(record *processRecord) {
+	record.cmd.Wait()
+
+	// The guest sends `goodbye` before it exits, but the message may be handled after the exit of
+	// the process, so wait for its connection to be closed before checking `stopping`.
+	self.waitForDisconnection(record.cmd.Process.Pid, time.Second)
+
+	// The log files are opened by the host, close them once the process exits.
+	closeAppLogs(record.cmd.Stdout, record.cmd.Stderr)
+
+	app := record.app
+	code, signal := getExitStatus(record.cmd.ProcessState)
+
+	self.processesLock.Lock()
+	stopping := record.stopping
+	respawn := record.respawn
+	respawnReason := record.respawnReason
+
+	if self.processes[app.Name] == record {
+		delete(self.processes, app.Name)
+	}
+
+	self.processesLock.Unlock()
+	self.recordExit(app.Name, code, signal)
+
+	var reason string
+
+	if signal != "" {
+		reason = fmt.Sprintf("was killed by signal %s", signal)
+	} else {
+		reason = fmt.Sprintf("exited with code %d", code)
+	}
+
+	if respawn && self.state.Load() == 1 {
+		self.logApp(app, "app [%v] %s", app.Name, reason)
+
+		if latest, ok := self.findAppConfig(app.Name); ok {
+			app = latest
+		}
+
+		if pid, err := self.spawnApp(app); err != nil {
+			self.logApp(app, "unable to restart app [%v]: %v", app.Name, err)
+		} else {
+			self.publishEvent(EventRestarted, app.Name, pid, respawnReason)
+		}
+	} else if stopping || self.state.Load() != 1 || self.standalone {
+		self.logApp(app, "app [%v] %s", app.Name, reason)
+	} else {
+		self.publishEvent(EventCrashed, app.Name, record.cmd.Process.Pid, reason)
+		self.reviveApp(app, code != 0 || signal != "", reason)
+	}
+}
+
+// openAppLogs opens the log writers for the app's stdout and stderr, the host server reads the
+// output of the app and writes it to the log files, so the files can be rotated.
+func openAppLogs(app config.App) (stdout io.Writer, stderr io.Writer, err error) {
+	if app.Stdout != "" {
+		if stdout, err = NewLogWriter(app.Name, app.Stdout, app.Log); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if filename := app.Stderr; filename != "" || app.Stdout != "" {
+		if filename == "" {
+			filename = app.Stdout
+		}
+
+		// Use a separate writer even if it's the same file, so the lines of stdout and stderr will
+		// not be mixed.
+		if stderr, err = NewLogWriter(app.Name, filename, app.Log); err != nil {
+			closeAppLogs(stdout, nil)
+			return nil, nil, err
+		}
+	}
+
+	return stdout, stderr, nil
+}
+
+func closeAppLogs(writers ...io.Writer) {
+	for _, writer := range writers {
+		if closer, ok := writer.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// reviveApp restarts the app that exited unexpectedly according to its restart policy.
+func (self *Host) reviveApp(app config.App, failed bool, reason string) {
+	restart, delay := self.recordCrash(app, failed)
+
+	if restart {
+		self.logApp(app, "app [%v] %s, reviving in %v...", app.Name, reason, delay)
+		time.Sleep(delay)
+
+		// The app may have been started by other means during the delay.
+		if self.state.Load() == 1 && !self.isSupervised(app.Name) {
+			if pid, err := self.spawnApp(app); err == nil {
+				self.publishEvent(EventRestarted, app.Name, pid, "revived after the crash")
+			}
+		}
+	} else if record := self.getRestartRecord(app.Name); record.Errored {
+		self.logApp(app, "app [%v] %s, crashed too many times, marked as errored", app.Name, reason)
+	} else {
+		self.logApp(app, "app [%v] %s", app.Name, reason)
+	}
+}
+
+// waitForDisconnection waits until the guest of the process is no longer connected, or the timeout
+// is reached.
+func (self *Host) waitForDisconnection(pid int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		_, connected := self.findClient(func(item clientRecord) bool {
+			return item.Pid == pid && item.App != ":cli"
+		})
+
+		if !connected {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// isSupervised checks if the app's process is spawned and supervised by the host server.
+func (self *Host) isSupervised(appName string) bool {
+	self.processesLock.Lock()
+	defer self.processesLock.Unlock()
+
+	_, ok := self.processes[appName]
+	return ok
+}
+
+// markStopping marks the app's process as stopping, so its exit will not trigger a restart.
+func (self *Host) markStopping(appName string) {
+	self.processesLock.Lock()
+	defer self.processesLock.Unlock()
+
+	if record, ok := self.processes[appName]; ok {
+		record.stopping = true
+	}
+}
+
+// markRespawn marks the app's process as to be respawned once it exits for the reason, and reports
+// whether the process of the given pid is supervised by the host server.
+func (self *Host) markRespawn(appName string, pid int, reason string) bool {
+	self.processesLock.Lock()
+	defer self.processesLock.Unlock()
+
+	if record, ok := self.processes[appName]; ok && record.cmd.Process.Pid == pid {
+		record.respawn = true
+		record.respawnReason = reason
+		return true
+	}
+
+	return false
+}
+
+// getSpawnedEnv returns the env that the process of the client is spawned with, or nil if the
+// process is not supervised by the host server.
+func (self *Host) getSpawnedEnv(client clientRecord) map[string]string {
+	self.processesLock.Lock()
+	defer self.processesLock.Unlock()
+
+	if record, ok := self.processes[client.App]; ok && record.cmd.Process.Pid == client.Pid {
+		return record.app.Env
+	}
+
+	return nil
+}
+
+// getApps loads the latest config of the apps, so changes made to the config file after the host
+// started will take effect.
+func (self *Host) getApps() []config.App {
+	if conf, err := config.LoadConfig(); err == nil {
+		return conf.Apps
+	}
+
+	return self.apps
+}
+
+// findAppConfig finds the latest config of the app, see `getApps()`.
+func (self *Host) findAppConfig(appName string) (config.App, bool) {
+	return slicex.Find(self.getApps(), func(item config.App, idx int) bool {
+		return item.Name == appName
+	})
+}
+
+func getExitStatus(state *os.ProcessState) (code int, signal string) {
+	code = state.ExitCode()
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		signal = status.Signal().String()
+	}
+
+	return code, signal
+}
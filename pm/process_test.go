@@ -0,0 +1,136 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHost_spawnApp(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	goext.Ok(0, util.CopyFile("../tsconfig.json", "tsconfig.json"))
+	goext.Ok(0, os.WriteFile("crash.sh", []byte("#!/bin/sh\nexit 3\n"), 0755))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("tsconfig.json")
+	defer os.Remove("crash.sh")
+	defer os.Remove("crash.log")
+
+	conf := goext.Ok(config.LoadConfig())
+	host := NewHost(conf, false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	app := config.App{
+		Name:         "crash-app",
+		Entry:        "crash.sh",
+		Stdout:       "crash.log",
+		Restart:      "on-failure",
+		MaxRestarts:  1,
+		RestartDelay: 10,
+	}
+	pid := goext.Ok(host.spawnApp(app))
+	assert.NotEqual(t, 0, pid)
+
+	time.Sleep(time.Millisecond * 500)
+
+	record := host.getRestartRecord("crash-app")
+	assert.Equal(t, 3, record.ExitCode)
+	assert.Equal(t, "", record.Signal)
+	assert.Equal(t, 1, record.Restarts)
+	assert.True(t, record.Errored)
+	assert.False(t, host.isSupervised("crash-app"))
+
+	log := string(goext.Ok(os.ReadFile("crash.log")))
+	assert.Contains(t, log, "app [crash-app] exited with code 3, reviving in 10ms...")
+	assert.Contains(t, log,
+		"app [crash-app] exited with code 3, crashed too many times, marked as errored")
+}
+
+func TestHost_spawnAppKilled(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	goext.Ok(0, util.CopyFile("../tsconfig.json", "tsconfig.json"))
+	goext.Ok(0, os.WriteFile("sleep.sh", []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("tsconfig.json")
+	defer os.Remove("sleep.sh")
+
+	conf := goext.Ok(config.LoadConfig())
+	host := NewHost(conf, false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	app := config.App{
+		Name:    "sleep-app",
+		Entry:   "sleep.sh",
+		Restart: "never",
+	}
+	pid := goext.Ok(host.spawnApp(app))
+	assert.True(t, host.isSupervised("sleep-app"))
+
+	proc := goext.Ok(os.FindProcess(pid))
+	goext.Ok(0, proc.Kill())
+	time.Sleep(time.Millisecond * 100)
+
+	record := host.getRestartRecord("sleep-app")
+	assert.Equal(t, -1, record.ExitCode)
+	assert.Equal(t, "killed", record.Signal)
+	assert.Equal(t, 0, record.Restarts)
+	assert.False(t, host.isSupervised("sleep-app"))
+}
+
+func TestSendCommand_startTimeout(t *testing.T) {
+	conf := `{"apps":[{"name":"hang-app","url":"grpc://localhost:4010","serve":true,` +
+		`"entry":"hang.sh","stdout":"hang.log","restart":"never","startTimeout":300}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	goext.Ok(0, os.WriteFile("hang.sh", []byte("#!/bin/sh\necho 'init error'\nexec sleep 10\n"), 0755))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("hang.sh")
+	defer os.Remove("hang.log")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	start := time.Now()
+	err := SendCommand("start", "hang-app")
+	assert.EqualError(t, err, "failed to start 1 app(s): hang-app")
+	assert.True(t, time.Since(start) >= time.Millisecond*300)
+
+	host.processesLock.Lock()
+	record := host.processes["hang-app"]
+	host.processesLock.Unlock()
+	record.cmd.Process.Kill()
+}
+
+func TestSendCommand_startWithFailedDependency(t *testing.T) {
+	conf := `{"apps":[` +
+		`{"name":"user-server","url":"grpc://localhost:4011","serve":true,"entry":"hang.sh",` +
+		`"dependsOn":["post-server"]},` +
+		`{"name":"post-server","url":"grpc://localhost:4012","serve":true,"entry":"hang.sh",` +
+		`"restart":"never","startTimeout":200}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	goext.Ok(0, os.WriteFile("hang.sh", []byte("#!/bin/sh\nexec sleep 10\n"), 0755))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("hang.sh")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	err := SendCommand("start", "")
+	assert.EqualError(t, err, "failed to start 2 app(s): post-server, user-server")
+	assert.False(t, host.isSupervised("user-server"))
+
+	host.processesLock.Lock()
+	record := host.processes["post-server"]
+	host.processesLock.Unlock()
+	record.cmd.Process.Kill()
+}
@@ -0,0 +1,186 @@
+package pm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/goext/stringx"
+)
+
+// ProtocolVersion is the version of the control protocol spoken over the host socket, the guests
+// send it in the `handshake` and the host server rejects the ones that speak another version. It
+// shall be bumped whenever the messages change incompatibly.
+//
+// The guests that don't send a version are considered speaking version 1.
+const ProtocolVersion = 2
+
+// Command is the type of a control message.
+type Command string
+
+const (
+	// guest -> host: signs in with `App`, `Pid`, `Url` and `Version`.
+	// host -> guest: the response, with the host's `Version`, or `Code` and `Error` if rejected.
+	CmdHandshake Command = "handshake"
+	// guest -> host: the app has finished its initialization.
+	CmdReady Command = "ready"
+	// guest <-> host: the peer is leaving, the connection shall be closed if `Fin` is set.
+	CmdGoodbye Command = "goodbye"
+	// The response of a request, correlated by the `MsgId` of the request. On failure, `Code` and
+	// `Error` are set.
+	CmdReply Command = "reply"
+	// host -> CLI: an app has come online, with `App` and `Pid`.
+	CmdOnline Command = "online"
+	// host -> watcher: the instances of the watched app in `Guests`.
+	CmdMembers Command = "members"
+	// host -> guest: probes the guest, which responds with a `pong` of the same `MsgId`.
+	CmdPing Command = "ping"
+	CmdPong Command = "pong"
+	// CLI -> host -> guest: control commands targeting `App`, or all apps if it's empty.
+	CmdStop    Command = "stop"
+	CmdReload  Command = "reload"
+	CmdDrain   Command = "drain"
+	CmdUndrain Command = "undrain"
+	// CLI -> host: replied with the `Guests` and `Restarts`.
+	CmdList Command = "list"
+	// CLI -> host: spawns `App` with the optional `Env`, replied with the `Pid`.
+	CmdSpawn Command = "spawn"
+	// CLI -> host: checks the health of `App`.
+	CmdHealth Command = "health"
+	// watcher -> host: subscribes to the membership of `App`, the `members` carry the `MsgId`.
+	CmdWatch Command = "watch"
+	// subscriber -> host: subscribes to the lifecycle events of the apps, acknowledged by a `reply`.
+	CmdSubscribe Command = "subscribe"
+	// host -> subscriber: a lifecycle event of an app in `Event`, with the `MsgId` of `subscribe`.
+	CmdEvent Command = "event"
+	// CLI -> host: resolves the actual `Url` of `App`.
+	CmdResolve Command = "resolve"
+	// CLI -> host: shuts down the host server.
+	CmdStopHost Command = "stop-host"
+)
+
+// ErrorCode tells the kind of failure replied along with the `Error` of a control message.
+type ErrorCode string
+
+const (
+	// The message is not a valid JSON of `ControlMessage`, e.g. it has unknown fields.
+	ErrInvalidMessage ErrorCode = "INVALID_MESSAGE"
+	// The receiver doesn't know how to handle the command.
+	ErrUnknownCommand ErrorCode = "UNKNOWN_COMMAND"
+	// The peers speak different versions of the protocol.
+	ErrVersionMismatch ErrorCode = "VERSION_MISMATCH"
+	// The app doesn't exist in the config file.
+	ErrAppNotFound ErrorCode = "APP_NOT_FOUND"
+	// The app is not running, or no app is running if the command targets all apps.
+	ErrAppNotRunning ErrorCode = "APP_NOT_RUNNING"
+	// The host server failed to spawn the app.
+	ErrSpawnFailed ErrorCode = "SPAWN_FAILED"
+	// The app failed the health check.
+	ErrUnhealthy ErrorCode = "UNHEALTHY"
+	// The app doesn't support the command, e.g. a Go app doesn't support `reload`.
+	ErrUnsupported ErrorCode = "UNSUPPORTED"
+	// The app didn't finish the command in time, e.g. its process didn't exit after `stop`.
+	ErrTimeout ErrorCode = "TIMEOUT"
+	// The app failed to handle the command, e.g. the `drain` handler returned an error.
+	ErrCommandFailed ErrorCode = "COMMAND_FAILED"
+)
+
+// ProtocolError is an error replied by the peer over the control protocol.
+type ProtocolError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (self *ProtocolError) Error() string {
+	return self.Message
+}
+
+func newProtocolError(code ErrorCode, format string, args ...any) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func newVersionMismatchError(hostVersion int, guestVersion int) *ProtocolError {
+	return newProtocolError(ErrVersionMismatch,
+		"protocol version mismatch (host server: v%d, client: v%d), make sure they're of the same "+
+			"version of ngrpc, and restart the host server if ngrpc has been upgraded",
+		hostVersion, guestVersion)
+}
+
+// getVersion returns the protocol version the peer speaks.
+func getVersion(msg ControlMessage) int {
+	if msg.Version == 0 {
+		return 1 // sent by a peer before the version was introduced
+	}
+
+	return msg.Version
+}
+
+// Err returns the error carried by the message, if any.
+func (self ControlMessage) Err() error {
+	if self.Error == "" {
+		return nil
+	}
+
+	return &ProtocolError{Code: self.Code, Message: self.Error}
+}
+
+// newReply creates the response of the request, correlated by its `MsgId`.
+func newReply(req ControlMessage) ControlMessage {
+	return ControlMessage{Cmd: CmdReply, App: req.App, MsgId: req.MsgId}
+}
+
+// newErrorReply creates the response of the request which failed with the error.
+func newErrorReply(req ControlMessage, err *ProtocolError) ControlMessage {
+	reply := newReply(req)
+	reply.Code = err.Code
+	reply.Error = err.Message
+	return reply
+}
+
+func newMsgId() string {
+	return stringx.Random(8)
+}
+
+// decodeMessages is like `DecodeMessage()`, except that it's strict on the message format, and
+// returns the errors of the chunks that cannot be decoded.
+func decodeMessages(packet *[]byte, bufRead []byte, eof bool) ([]ControlMessage, []error) {
+	*packet = append(*packet, bufRead...)
+	chunks := slicex.Split(*packet, byte('\n'))
+
+	if eof {
+		// Empty the packet when reaching EOF.
+		*packet = []byte{}
+		// Returns all non-empty chunks, normally the last chunk is empty.
+		chunks = slicex.Filter(chunks, func(chunk []byte, _ int) bool {
+			return len(chunk) > 0
+		})
+	} else if len(chunks) > 1 {
+		// The last chunk is unfinished, we store it in the packet for more data.
+		*packet = chunks[len(chunks)-1]
+		// All chunks (except the last one) will be processed.
+		chunks = chunks[:len(chunks)-1]
+	} else { // len(chunk) == 1
+		// We use `\n` to delimit message packets, each packet ends with a `\n`, when len(chunks)
+		// is 1, it means that the delimiter haven't been received and there is more buffers needs
+		// to be received, no available chunks for consuming yet.
+		return nil, nil
+	}
+
+	messages := []ControlMessage{}
+	errs := []error{}
+
+	for _, chunk := range chunks {
+		var msg ControlMessage
+		decoder := json.NewDecoder(bytes.NewReader(chunk))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(&msg); err != nil {
+			errs = append(errs, newProtocolError(ErrInvalidMessage, "invalid message: %v", err))
+		} else {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages, errs
+}
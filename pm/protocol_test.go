@@ -0,0 +1,147 @@
+package pm
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/pm/socket"
+	"github.com/ayonli/ngrpc/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// exchange sends the raw data to the host server and returns the first message replied.
+func exchange(t *testing.T, data []byte) ControlMessage {
+	_, sockPath := GetSocketPath()
+	conn := goext.Ok(socket.DialTimeout(sockPath, time.Second))
+	defer conn.Close()
+
+	goext.Ok(conn.Write(data))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	packet := []byte{}
+	buf := make([]byte, 256)
+
+	for {
+		n, err := conn.Read(buf)
+
+		if messages := DecodeMessage(&packet, buf[:n], err != nil); len(messages) > 0 {
+			return messages[0]
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeMessage_strict(t *testing.T) {
+	packet := []byte{}
+	data := []byte(`{"cmd":"stop","unknown":1}` + "\n" + `{"cmd":"stop"` + "\n")
+	messages, errs := decodeMessages(&packet, data, false)
+
+	assert.Equal(t, 0, len(messages))
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, ErrInvalidMessage, errs[0].(*ProtocolError).Code)
+	assert.Equal(t, `invalid message: json: unknown field "unknown"`, errs[0].Error())
+}
+
+func TestHost_protocol(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	defer os.Remove("ngrpc.json")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	// A guest of another version is rejected.
+	reply := exchange(t, EncodeMessage(ControlMessage{Cmd: CmdHandshake, App: "example-server"}))
+	assert.Equal(t, CmdHandshake, reply.Cmd)
+	assert.Equal(t, ErrVersionMismatch, reply.Code)
+	assert.Equal(t, ProtocolVersion, reply.Version)
+	assert.Equal(t, "protocol version mismatch (host server: v2, client: v1), make sure they're "+
+		"of the same version of ngrpc, and restart the host server if ngrpc has been upgraded",
+		reply.Error)
+	assert.True(t, reply.Fin)
+	_, exists := host.findClient(func(item clientRecord) bool {
+		return item.App == "example-server"
+	})
+	assert.False(t, exists)
+
+	reply = exchange(t, EncodeMessage(ControlMessage{Cmd: CmdResolve, App: "example-server"}))
+	assert.Equal(t, ErrVersionMismatch, reply.Code)
+
+	// The replies are correlated by the `MsgId` of the requests.
+	reply = exchange(t, EncodeMessage(ControlMessage{Cmd: CmdList, MsgId: "abc"}))
+	assert.Equal(t, CmdReply, reply.Cmd)
+	assert.Equal(t, "abc", reply.MsgId)
+	assert.Equal(t, []clientRecord{}, reply.Guests)
+
+	reply = exchange(t, EncodeMessage(ControlMessage{
+		Cmd:   CmdStop,
+		App:   "user-server",
+		MsgId: "def",
+	}))
+	assert.Equal(t, "def", reply.MsgId)
+	assert.Equal(t, ErrAppNotRunning, reply.Code)
+	assert.Equal(t, "app [user-server] is not running", reply.Error)
+
+	reply = exchange(t, EncodeMessage(ControlMessage{Cmd: CmdSpawn, App: "no-app", MsgId: "ghi"}))
+	assert.Equal(t, "ghi", reply.MsgId)
+	assert.Equal(t, ErrAppNotFound, reply.Code)
+
+	reply = exchange(t, EncodeMessage(ControlMessage{Cmd: "unknown", MsgId: "jkl"}))
+	assert.Equal(t, "jkl", reply.MsgId)
+	assert.Equal(t, ErrUnknownCommand, reply.Code)
+	assert.Equal(t, "unknown command 'unknown'", reply.Error)
+
+	reply = exchange(t, []byte(`{"cmd":"list","foo":"bar"}`+"\n"))
+	assert.Equal(t, ErrInvalidMessage, reply.Code)
+
+	// The error is returned as a `ProtocolError` by the client.
+	_, err := ResolveAppUrl("user-server")
+	var protoErr *ProtocolError
+	assert.True(t, errors.As(err, &protoErr))
+	assert.Equal(t, ErrAppNotRunning, protoErr.Code)
+}
+
+func TestGuest_versionMismatch(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	defer os.Remove("ngrpc.json")
+
+	// A host server of the old version, which doesn't check the version.
+	sockFile, sockPath := GetSocketPath()
+	listener := goext.Ok(socket.Listen(sockPath))
+	defer os.Remove(sockFile)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				break
+			}
+
+			go func(conn net.Conn) {
+				buf := make([]byte, 256)
+				conn.Read(buf)
+				conn.Write([]byte(`{"cmd":"handshake"}` + "\n"))
+			}(conn)
+		}
+	}()
+
+	guest := NewGuest(config.App{Name: "example-server"}, func(msgId string) {})
+	err := guest.connect()
+	assert.True(t, isVersionMismatch(err))
+	assert.Equal(t, "protocol version mismatch (host server: v1, client: v2), make sure they're "+
+		"of the same version of ngrpc, and restart the host server if ngrpc has been upgraded",
+		err.Error())
+	assert.Equal(t, int32(0), guest.state.Load())
+
+	// The CLI reports the mismatch instead of printing an empty list.
+	err = SendCommand("list", "")
+	assert.True(t, isVersionMismatch(err))
+}
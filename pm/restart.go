@@ -0,0 +1,88 @@
+package pm
+
+import (
+	"time"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/config"
+)
+
+const (
+	defaultMaxRestarts   = 10
+	defaultRestartWindow = time.Minute
+	defaultRestartDelay  = time.Second
+	maxRestartDelay      = time.Second * 30
+)
+
+// restartRecord keeps track of the restarts of an app, which is used for implementing the restart
+// policy and crash-loop detection.
+type restartRecord struct {
+	App       string `json:"app"`
+	Restarts  int    `json:"restarts"`
+	LastCrash int    `json:"lastCrash"`
+	// The app has crashed too many times and will not be restarted anymore.
+	Errored bool `json:"errored"`
+	// The exit code and the signal (if killed by a signal) of the last exit of the app, only
+	// available if the app is spawned by the host server.
+	ExitCode int    `json:"exitCode"`
+	Signal   string `json:"signal"`
+
+	// The times of the crashes within the restart window.
+	crashes []time.Time
+}
+
+// recordCrash records an unexpected exit of the app, and reports whether the app should be
+// restarted and how long to wait before restarting it. `failed` indicates whether the app exited
+// with failure, which is used by the `on-failure` policy.
+func (self *restartRecord) recordCrash(
+	app config.App,
+	failed bool,
+	now time.Time,
+) (bool, time.Duration) {
+	window := defaultRestartWindow
+	delay := defaultRestartDelay
+	maxRestarts := defaultMaxRestarts
+
+	if app.RestartWindow > 0 {
+		window = time.Duration(app.RestartWindow) * time.Millisecond
+	}
+
+	if app.RestartDelay > 0 {
+		delay = time.Duration(app.RestartDelay) * time.Millisecond
+	}
+
+	if app.MaxRestarts != 0 {
+		maxRestarts = app.MaxRestarts
+	}
+
+	self.LastCrash = int(now.Unix())
+	self.crashes = append(slicex.Filter(self.crashes, func(item time.Time, idx int) bool {
+		return now.Sub(item) < window
+	}), now)
+
+	if app.Restart == "never" || (app.Restart == "on-failure" && !failed) {
+		return false, 0
+	} else if maxRestarts > 0 && len(self.crashes) > maxRestarts {
+		self.Errored = true
+		return false, 0
+	}
+
+	// Use exponential backoff for consecutive crashes.
+	for i := 1; i < len(self.crashes) && delay < maxRestartDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRestartDelay {
+		delay = maxRestartDelay
+	}
+
+	self.Restarts++
+	return true, delay
+}
+
+// reset clears the crash history, it's called when the app is started manually after it's been
+// marked as errored.
+func (self *restartRecord) reset() {
+	self.Errored = false
+	self.crashes = nil
+}
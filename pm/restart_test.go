@@ -0,0 +1,89 @@
+package pm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestartRecord_recordCrash(t *testing.T) {
+	app := config.App{Name: "example-server"}
+	record := &restartRecord{App: app.Name}
+	now := time.Now()
+
+	restart1, delay1 := record.recordCrash(app, true, now)
+	restart2, delay2 := record.recordCrash(app, true, now.Add(time.Second))
+	restart3, delay3 := record.recordCrash(app, true, now.Add(time.Second*2))
+
+	assert.True(t, restart1)
+	assert.True(t, restart2)
+	assert.True(t, restart3)
+	assert.Equal(t, time.Second, delay1)
+	assert.Equal(t, time.Second*2, delay2)
+	assert.Equal(t, time.Second*4, delay3)
+	assert.Equal(t, 3, record.Restarts)
+	assert.Equal(t, int(now.Add(time.Second*2).Unix()), record.LastCrash)
+
+	// crashes out of the window are not counted
+	restart4, delay4 := record.recordCrash(app, true, now.Add(time.Minute*2))
+	assert.True(t, restart4)
+	assert.Equal(t, time.Second, delay4)
+	assert.Equal(t, 4, record.Restarts)
+}
+
+func TestRestartRecord_recordCrashMaxDelay(t *testing.T) {
+	app := config.App{Name: "example-server", MaxRestarts: -1}
+	record := &restartRecord{App: app.Name}
+	now := time.Now()
+	var delay time.Duration
+
+	for i := 0; i < 20; i++ {
+		_, delay = record.recordCrash(app, true, now)
+	}
+
+	assert.Equal(t, maxRestartDelay, delay)
+	assert.False(t, record.Errored)
+}
+
+func TestRestartRecord_recordCrashErrored(t *testing.T) {
+	app := config.App{Name: "example-server", MaxRestarts: 2, RestartDelay: 100}
+	record := &restartRecord{App: app.Name}
+	now := time.Now()
+
+	restart1, delay1 := record.recordCrash(app, true, now)
+	restart2, delay2 := record.recordCrash(app, true, now)
+	restart3, _ := record.recordCrash(app, true, now)
+
+	assert.True(t, restart1)
+	assert.True(t, restart2)
+	assert.False(t, restart3)
+	assert.Equal(t, time.Millisecond*100, delay1)
+	assert.Equal(t, time.Millisecond*200, delay2)
+	assert.Equal(t, 2, record.Restarts)
+	assert.True(t, record.Errored)
+
+	record.reset()
+	restart4, _ := record.recordCrash(app, true, now)
+
+	assert.True(t, restart4)
+	assert.False(t, record.Errored)
+}
+
+func TestRestartRecord_recordCrashPolicy(t *testing.T) {
+	never := config.App{Name: "example-server", Restart: "never"}
+	onFailure := config.App{Name: "user-server", Restart: "on-failure"}
+	record1 := &restartRecord{App: never.Name}
+	record2 := &restartRecord{App: onFailure.Name}
+
+	restart1, _ := record1.recordCrash(never, true, time.Now())
+	restart2, _ := record2.recordCrash(onFailure, false, time.Now())
+	restart3, _ := record2.recordCrash(onFailure, true, time.Now())
+
+	assert.False(t, restart1)
+	assert.False(t, restart2)
+	assert.True(t, restart3)
+	assert.Equal(t, 0, record1.Restarts)
+	assert.NotEqual(t, 0, record1.LastCrash)
+}
@@ -0,0 +1,96 @@
+package pm
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/config"
+)
+
+// rollingRestart restarts the apps one by one in the order of their dependencies, an app is only
+// restarted after the previous one is back online and healthy, so the other replicas keep serving
+// during the restart. Once an app fails to restart, the remaining apps are left untouched.
+//
+// NOTE: this function runs in the CLI instead of the host server.
+func (self *Host) rollingRestart(appName string, guest *Guest) error {
+	apps, err := self.loadApps(appName)
+
+	if len(apps) == 0 || err != nil {
+		guest.Leave("", "")
+		return err
+	}
+
+	groups, err := config.GroupApps(apps)
+
+	if err != nil {
+		guest.Leave("", "")
+		return err
+	}
+
+	apps = slicex.Flat(groups)
+
+	for i, app := range apps {
+		err := self.sendAndWait(ControlMessage{Cmd: CmdStop, App: app.Name}, guest, false)
+
+		if err != nil {
+			log.Println(err)
+		}
+
+		if failed := self.spawnAndWait([]config.App{app}, guest); len(failed) > 0 {
+			err = fmt.Errorf("app [%s] failed to start", app.Name)
+		} else {
+			err = self.waitHealthy(app, guest)
+		}
+
+		if err != nil {
+			guest.Leave("", "")
+			remains := slicex.Map(apps[i+1:], func(app config.App, _ int) string {
+				return app.Name
+			})
+
+			if len(remains) > 0 {
+				return fmt.Errorf("rolling restart aborted: %v, %d app(s) not restarted: %s",
+					err, len(remains), strings.Join(remains, ", "))
+			} else {
+				return fmt.Errorf("rolling restart aborted: %v", err)
+			}
+		}
+	}
+
+	guest.Leave("", "")
+	return nil
+}
+
+// waitHealthy asks the host server to check the health of the app until it's healthy or the
+// `startTimeout` is reached.
+//
+// NOTE: this function runs in the CLI instead of the host server.
+func (self *Host) waitHealthy(app config.App, guest *Guest) error {
+	deadline := time.Now().Add(getStartTimeout(app))
+
+	for {
+		msgId := guest.request(ControlMessage{Cmd: CmdHealth, App: app.Name})
+		var reply ControlMessage
+
+		for reply = range guest.replyChan {
+			if reply.Cmd == CmdGoodbye || (reply.Cmd == CmdReply && reply.MsgId == msgId) {
+				break
+			}
+		}
+
+		if reply.Cmd == CmdGoodbye {
+			return errors.New("host server has shut down")
+		} else if reply.Error == "" {
+			log.Printf("app [%s] is healthy", app.Name)
+			return nil
+		} else if time.Now().After(deadline) {
+			return errors.New(reply.Error)
+		}
+
+		time.Sleep(time.Millisecond * 500)
+	}
+}
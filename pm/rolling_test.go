@@ -0,0 +1,152 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// A minimal app that serves the gRPC health service and joins the host server.
+const rollingAppCode = `package main
+
+import (
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/pm"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func main() {
+	conf := goext.Ok(config.LoadConfig())
+	app, _ := slicex.Find(conf.Apps, func(app config.App, _ int) bool {
+		return app.Name == os.Args[len(os.Args)-1]
+	})
+	urlObj := goext.Ok(url.Parse(app.Url))
+	listener := goext.Ok(net.Listen("tcp", config.GetAddress(urlObj)))
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+
+	var guest *pm.Guest
+	guest = pm.NewGuest(app, func(msgId string) {
+		server.Stop()
+		guest.Leave("app ["+app.Name+"] stopped", msgId)
+		os.Exit(0)
+	})
+	guest.Join()
+	guest.Ready()
+	select {}
+}
+`
+
+func getRollingTestPids(host *Host) map[string]int {
+	pids := map[string]int{}
+
+	for _, client := range host.filterClients(func(item clientRecord) bool {
+		return item.Ready && item.App != ":cli"
+	}) {
+		pids[client.App] = client.Pid
+	}
+
+	return pids
+}
+
+func TestSendCommand_rollingRestart(t *testing.T) {
+	conf := `{"apps":[` +
+		`{"name":"rolling-app","url":"grpc://localhost:{4020+i}","serve":true,"instances":2,` +
+		`"entry":"testdata/rolling/main.go","stdout":"rolling.log"},` +
+		`{"name":"front-app","url":"grpc://localhost:4022","serve":true,` +
+		`"entry":"testdata/rolling/main.go","stdout":"rolling.log","dependsOn":["rolling-app"]}` +
+		`]}`
+	goext.Ok(0, os.MkdirAll("testdata/rolling", 0755))
+	goext.Ok(0, os.WriteFile("testdata/rolling/main.go", []byte(rollingAppCode), 0644))
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	goext.Ok(0, os.WriteFile("fail.sh", []byte("#!/bin/sh\nexit 1\n"), 0755))
+	defer os.RemoveAll("testdata")
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("fail.sh")
+	defer os.Remove("rolling.log")
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	goext.Ok(0, SendCommand("start", ""))
+	pids := getRollingTestPids(host)
+	assert.Equal(t, 3, len(pids))
+
+	// Record the order in which the instances come online.
+	order := []string{}
+	done := make(chan bool)
+	cli := NewGuest(config.App{Name: ":cli"}, func(msgId string) {})
+	cli.replyChan = make(chan ControlMessage, 10)
+	goext.Ok(0, cli.connect())
+	defer cli.Leave("", "")
+
+	go func() {
+		for msg := range cli.replyChan {
+			if msg.Cmd == "online" {
+				order = append(order, msg.App)
+			} else if msg.Cmd == "goodbye" {
+				break
+			}
+
+			if len(order) == 3 {
+				break
+			}
+		}
+
+		done <- true
+	}()
+
+	goext.Ok(0, SendCommand("restart", "", CommandOptions{Rolling: true}))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+	}
+
+	// Only one instance is replaced at a time, in the order of their dependencies.
+	assert.Equal(t, []string{"rolling-app#0", "rolling-app#1", "front-app"}, order)
+
+	newPids := getRollingTestPids(host)
+	assert.Equal(t, 3, len(newPids))
+
+	for name, pid := range pids {
+		assert.NotEqual(t, pid, newPids[name], name)
+	}
+
+	// If a replacement fails, the remaining instances are left running.
+	conf = strings.Replace(conf, `"instances":2,"entry":"testdata/rolling/main.go"`,
+		`"instances":2,"entry":"fail.sh","startTimeout":500`, 1)
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+
+	err := SendCommand("restart", "", CommandOptions{Rolling: true})
+	assert.EqualError(t, err, "rolling restart aborted: app [rolling-app#0] failed to start, "+
+		"2 app(s) not restarted: rolling-app#1, front-app")
+
+	pids = getRollingTestPids(host)
+	assert.Equal(t, 2, len(pids))
+	assert.Equal(t, newPids["rolling-app#1"], pids["rolling-app#1"])
+	assert.Equal(t, newPids["front-app"], pids["front-app"])
+
+	// Stop the instances without stopping the host server.
+	SendCommand("stop", "rolling-app")
+	SendCommand("stop", "front-app")
+	time.Sleep(time.Millisecond * 200) // wait for the exits to be logged
+}
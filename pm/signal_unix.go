@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// signalProcess sends the signal to the process, or to its process group if the process leads its
+// own group, so the descendants spawned by the app receive the signal as well.
+func signalProcess(pid int, signal syscall.Signal) error {
+	if pgid, err := syscall.Getpgid(pid); err == nil && pgid == pid && pgid != syscall.Getpgrp() {
+		return syscall.Kill(-pgid, signal)
+	}
+
+	return syscall.Kill(pid, signal)
+}
+
+func isProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// execProcess replaces the current process with the program, it only returns on failure.
+func execProcess(program string, args []string) error {
+	return syscall.Exec(program, append([]string{program}, args...), os.Environ())
+}
@@ -0,0 +1,62 @@
+//go:build windows
+// +build windows
+
+package pm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// signalProcess sends the signal to the process, only SIGKILL is supported on Windows.
+func signalProcess(pid int, signal syscall.Signal) error {
+	if signal != syscall.SIGKILL {
+		return fmt.Errorf("signal %v is not supported on Windows", signal)
+	}
+
+	proc, err := os.FindProcess(pid)
+
+	if err != nil {
+		return err
+	}
+
+	return proc.Kill()
+}
+
+// The access right to wait for the process, see `isProcessAlive()`.
+const processSynchronize = 0x00100000
+
+// isProcessAlive waits on the handle of the process without blocking, since `os.FindProcess()`
+// succeeds as long as the process can be opened, even if it has exited.
+func isProcessAlive(pid int) bool {
+	handle, err := syscall.OpenProcess(processSynchronize, false, uint32(pid))
+
+	if err != nil {
+		// The process exists but belongs to someone else.
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+
+	defer syscall.CloseHandle(handle)
+	event, err := syscall.WaitForSingleObject(handle, 0)
+
+	return err == nil && event == syscall.WAIT_TIMEOUT
+}
+
+// execProcess runs the program with the standard I/O of the current process, since a process cannot
+// be replaced on Windows, and exits with its exit code once it exits. It only returns on failure.
+func execProcess(program string, args []string) error {
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil && cmd.ProcessState == nil {
+		return err
+	}
+
+	os.Exit(cmd.ProcessState.ExitCode())
+	return nil
+}
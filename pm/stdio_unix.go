@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// redirectStderr points the standard error of the process to the file.
+func redirectStderr(file *os.File) error {
+	return unix.Dup2(int(file.Fd()), int(os.Stderr.Fd()))
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package pm
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// redirectStderr points the standard error of the process to the file, the runtime looks up the
+// handle every time it writes, so the panics are written to the file.
+func redirectStderr(file *os.File) error {
+	return windows.SetStdHandle(windows.STD_ERROR_HANDLE, windows.Handle(file.Fd()))
+}
@@ -0,0 +1,117 @@
+package pm
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// stopClient asks the app instance to stop and waits for its process to exit within the timeout of
+// the request. If `Force` is set and the process is still alive, it's sent SIGTERM, then SIGKILL,
+// each signal is given the same timeout, and the reply tells which step terminated the app.
+func (self *Host) stopClient(client clientRecord, req ControlMessage) ControlMessage {
+	timeout := time.Duration(req.Timeout) * time.Millisecond
+
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	msg := ControlMessage{App: client.App, MsgId: req.MsgId}
+	replyChan := make(chan ControlMessage, 1)
+	msgId := self.sendRequest(client, ControlMessage{Cmd: CmdStop}, func(reply ControlMessage) {
+		replyChan <- reply
+	})
+
+	var reply ControlMessage
+
+	select {
+	case reply = <-replyChan:
+		// The app may exit without replying, which is fine.
+		if reply.Error != "" && reply.Code != ErrAppNotRunning {
+			return reply
+		}
+	case <-time.After(timeout):
+		self.callbacks.Delete(msgId)
+	}
+
+	// The app replies before its process exits, and the process may be kept alive by other logics.
+	if waitForExit(client.Pid, time.Until(deadline)) {
+		if reply.Error != "" || reply.Text == "" {
+			reply = newReply(msg)
+			reply.Text = fmt.Sprintf("app [%s] stopped", client.App)
+		}
+
+		return reply
+	}
+
+	// Never signal the host server itself, e.g. the app runs in the same process in the tests.
+	if !req.Force || client.Pid == os.Getpid() {
+		return newErrorReply(msg, newProtocolError(ErrTimeout, "app [%s] didn't exit within %v",
+			client.App, timeout))
+	}
+
+	// From now on, the exit of the process is expected, it shall neither be treated as a crash nor
+	// be revived.
+	self.markStopping(client.App)
+	detached := self.removeClient(func(item clientRecord) bool {
+		return item.conn == client.conn
+	})
+
+	if detached {
+		self.notifyWatchers(client.App)
+	}
+
+	app, _ := self.findAppConfig(client.App)
+	steps := []struct {
+		name   string
+		signal syscall.Signal
+	}{
+		{name: "SIGTERM", signal: syscall.SIGTERM},
+		{name: "SIGKILL", signal: syscall.SIGKILL},
+	}
+
+	for _, step := range steps {
+		self.logApp(app, "app [%v] didn't exit within %v, sending %s...",
+			client.App, timeout, step.name)
+
+		if err := signalProcess(client.Pid, step.signal); err != nil && isProcessAlive(client.Pid) {
+			// e.g. SIGTERM is not supported on Windows, move on to the next step.
+			self.logApp(app, "unable to send %s to app [%v]: %v", step.name, client.App, err)
+			continue
+		}
+
+		if waitForExit(client.Pid, timeout) {
+			reason := "terminated by " + step.name
+
+			if detached {
+				self.publishEvent(EventOffline, client.App, client.Pid, reason)
+			}
+
+			reply = newReply(msg)
+			reply.Text = fmt.Sprintf("app [%s] didn't exit within %v, %s",
+				client.App, timeout, reason)
+			return reply
+		}
+	}
+
+	return newErrorReply(msg, newProtocolError(ErrTimeout, "app [%s] is still alive after SIGKILL",
+		client.App))
+}
+
+// waitForExit waits until the process exits, it reports whether the process exited before the
+// timeout.
+func waitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for isProcessAlive(pid) {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	return true
+}
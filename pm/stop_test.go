@@ -0,0 +1,116 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// startHungApp spawns a process which never exits by itself, and joins the group on behalf of it
+// without responding to the `stop` command.
+func startHungApp(t *testing.T, script string) (*Host, int) {
+	conf := `{"apps":[{"name":"hung-app","url":"grpc://localhost:4016","entry":"hung.sh"}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	goext.Ok(0, os.WriteFile("hung.sh", []byte(script), 0755))
+	t.Cleanup(func() {
+		os.Remove("ngrpc.json")
+		os.Remove("hung.sh")
+	})
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	t.Cleanup(host.Stop)
+
+	pid := goext.Ok(host.spawnApp(host.apps[0]))
+	conn := joinSilently("hung-app", pid, &atomic.Bool{})
+	t.Cleanup(func() { conn.Close() })
+	waitClientState(t, host, "hung-app", false)
+
+	return host, pid
+}
+
+func TestHost_stopTimeout(t *testing.T) {
+	host, pid := startHungApp(t, "#!/bin/sh\nexec sleep 10\n")
+
+	reply := exchange(t, EncodeMessage(ControlMessage{
+		Cmd:     CmdStop,
+		App:     "hung-app",
+		MsgId:   "abc",
+		Timeout: 100,
+	}))
+	assert.Equal(t, "abc", reply.MsgId)
+	assert.Equal(t, ErrTimeout, reply.Code)
+	assert.Equal(t, "app [hung-app] didn't exit within 100ms", reply.Error)
+	assert.True(t, reply.Fin)
+
+	// The CLI fails with the error of the reply.
+	err := SendCommand("stop", "hung-app", CommandOptions{Timeout: time.Millisecond * 100})
+	assert.Equal(t, &ProtocolError{
+		Code:    ErrTimeout,
+		Message: "app [hung-app] didn't exit within 100ms",
+	}, err)
+
+	// Without `Force`, the process is left alone.
+	assert.True(t, isProcessAlive(pid))
+	assert.True(t, host.isSupervised("hung-app"))
+
+	host.markStopping("hung-app")
+	killProcess(pid)
+	time.Sleep(time.Millisecond * 100) // wait for the exit to be handled
+}
+
+func TestHost_stopForce(t *testing.T) {
+	host, pid := startHungApp(t, "#!/bin/sh\nexec sleep 10\n")
+
+	events := make(chan AppEvent, 10)
+	stream := goext.Ok(SubscribeEvents(func(event AppEvent) { events <- event }))
+	defer stream.Close()
+
+	reply := exchange(t, EncodeMessage(ControlMessage{
+		Cmd:     CmdStop,
+		App:     "hung-app",
+		MsgId:   "abc",
+		Timeout: 100,
+		Force:   true,
+	}))
+	assert.Equal(t, "abc", reply.MsgId)
+	assert.Equal(t, "", reply.Error)
+	assert.Equal(t, "app [hung-app] didn't exit within 100ms, terminated by SIGTERM", reply.Text)
+	assert.False(t, isProcessAlive(pid))
+
+	event := waitEvent(t, events)
+	assert.Equal(t, EventOffline, event.Type)
+	assert.Equal(t, pid, event.Pid)
+	assert.Equal(t, "terminated by SIGTERM", event.Reason)
+
+	// The exit is expected, so the app is neither considered crashed nor revived.
+	time.Sleep(time.Millisecond * 100)
+	assert.False(t, host.isSupervised("hung-app"))
+	assert.Equal(t, 0, len(events))
+}
+
+func TestHost_stopForceKill(t *testing.T) {
+	host, pid := startHungApp(t, "#!/bin/sh\ntrap '' TERM\nexec sleep 10\n")
+
+	reply := exchange(t, EncodeMessage(ControlMessage{
+		Cmd:     CmdStop,
+		App:     "hung-app",
+		MsgId:   "abc",
+		Timeout: 100,
+		Force:   true,
+	}))
+	assert.Equal(t, "", reply.Error)
+	assert.Equal(t, "app [hung-app] didn't exit within 100ms, terminated by SIGKILL", reply.Text)
+	assert.False(t, isProcessAlive(pid))
+
+	time.Sleep(time.Millisecond * 100) // wait for the exit to be handled
+	assert.False(t, host.isSupervised("hung-app"))
+}
@@ -0,0 +1,196 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// The stress tests exercise the host server with many guests joining and leaving concurrently, they
+// are meant to be run with `go test -race`.
+
+const numStressGuests = 50
+
+func startStressHost(t *testing.T) *Host {
+	// The guests are not in the config file, so they're not revived when disconnected.
+	conf := `{"apps":[{"name":"stress-idle","url":"grpc://localhost:4099"}]}`
+	goext.Ok(0, os.WriteFile("ngrpc.json", []byte(conf), 0644))
+	t.Cleanup(func() { os.Remove("ngrpc.json") })
+
+	host := NewHost(goext.Ok(config.LoadConfig()), false)
+	goext.Ok(0, host.Start(false))
+	t.Cleanup(host.Stop)
+
+	return host
+}
+
+func waitStress(t *testing.T, wg *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("the stress test didn't finish in time, something is deadlocked")
+	}
+}
+
+// waitNoGuests waits for the host server to remove all the stress guests.
+func waitNoGuests(t *testing.T, host *Host) {
+	deadline := time.Now().Add(time.Second * 2)
+
+	for time.Now().Before(deadline) {
+		if len(host.filterClients(func(item clientRecord) bool {
+			return strings.HasPrefix(item.App, "stress-app")
+		})) == 0 {
+			return
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Fatal("some guests are still registered on the host server")
+}
+
+func TestStress_joinAndLeave(t *testing.T) {
+	host := startStressHost(t)
+
+	members := make(chan []string, 1000)
+	watcher := goext.Ok(WatchApp("stress-app", func(urls []string) {
+		members <- urls
+	}))
+	defer watcher.Close()
+
+	wg := sync.WaitGroup{}
+	wg.Add(numStressGuests)
+
+	for i := 0; i < numStressGuests; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			for round := 0; round < 3; round++ {
+				app := config.App{
+					Name: fmt.Sprintf("stress-app#%d", i),
+					Url:  fmt.Sprintf("grpc://localhost:%d", 5000+i),
+				}
+				guest := NewGuest(app, func(msgId string) {})
+				guest.Join()
+				guest.Ready()
+				time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+
+				if i%5 == 0 && round == 0 {
+					// Lose the connection, the guest reconnects in the background and leaves
+					// either before or after it's reconnected.
+					guest.getConn().Close()
+					time.Sleep(time.Duration(rand.Intn(1500)) * time.Millisecond)
+				}
+
+				guest.Leave("", "")
+			}
+		}(i)
+	}
+
+	// Read the state of the host server while the guests come and go.
+	go func() {
+		for i := 0; i < 100; i++ {
+			host.getClients()
+			host.findClient(func(item clientRecord) bool {
+				return item.Ready
+			})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	waitStress(t, &wg, time.Second*20)
+	waitNoGuests(t, host)
+
+	// The watcher eventually learns that all the instances are gone.
+	deadline := time.After(time.Second * 2)
+
+	for {
+		select {
+		case urls := <-members:
+			if len(urls) == 0 && len(members) == 0 {
+				return
+			}
+		case <-deadline:
+			t.Fatal("the watcher didn't receive the final membership")
+		}
+	}
+}
+
+func TestStress_commands(t *testing.T) {
+	host := startStressHost(t)
+	guests := make([]*Guest, numStressGuests)
+	stopped := sync.WaitGroup{}
+	stopped.Add(numStressGuests)
+
+	for i := range guests {
+		app := config.App{Name: fmt.Sprintf("stress-app#%d", i)}
+		var guest *Guest
+		once := sync.Once{}
+		guest = NewGuest(app, func(msgId string) {
+			// The guest may receive `stop` several times, or leave by itself while stopping.
+			guest.Leave(fmt.Sprintf("app [%s] stopped", app.Name), msgId)
+			once.Do(stopped.Done)
+		})
+		guest.Join()
+		guest.Ready()
+		guests[i] = guest
+	}
+
+	wg := sync.WaitGroup{}
+
+	// Some guests leave by themselves while the commands are broadcast to them.
+	for i := 0; i < numStressGuests; i += 7 {
+		wg.Add(1)
+		go func(guest *Guest) {
+			defer wg.Done()
+			time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+			guest.handleStopCommand("")
+		}(guests[i])
+	}
+
+	for i := 0; i < 5; i++ {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			SendCommand("drain", "stress-app")
+		}()
+
+		go func() {
+			defer wg.Done()
+			SendCommand("undrain", "stress-app")
+		}()
+
+		go func(i int) {
+			defer wg.Done()
+			SendCommand("stop", fmt.Sprintf("stress-app#%d", i*3+1))
+		}(i)
+	}
+
+	waitStress(t, &wg, time.Second*20)
+
+	// Stop the remaining guests all at once.
+	goext.Ok(0, SendCommand("stop", "stress-app"))
+	waitStress(t, &stopped, time.Second*5)
+	waitNoGuests(t, host)
+
+	assert.Equal(t, 0, host.callbacks.Size())
+}
@@ -0,0 +1,361 @@
+package pm
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/config"
+)
+
+// SystemdOptions holds the options to generate the systemd units.
+type SystemdOptions struct {
+	// Generate user units (`systemctl --user`) instead of system units.
+	User bool
+	// Generate a unit for each app as well, the replicas of an app share a template unit
+	// `ngrpc-<app>@.service`, whose instance is the index of the replica.
+	Apps bool
+	// The absolute path of the `ngrpc` executable.
+	Executable string
+	// The absolute path of the project directory, where the config file is.
+	WorkDir string
+	// The user to run the system units, ignored for user units.
+	RunAs string
+}
+
+// SystemdUnit is a generated unit file.
+type SystemdUnit struct {
+	Name    string
+	Content string
+	// The names to enable the unit, for a template unit, they're the instances of the replicas.
+	Instances []string
+}
+
+const systemdHostUnit = "ngrpc-host.service"
+
+var invalidUnitChars = regexp.MustCompile(`[^A-Za-z0-9:_.\-]`)
+
+// GetSystemdUnitDir returns the directory where the unit files shall be installed.
+func GetSystemdUnitDir(user bool) string {
+	if user {
+		return "~/.config/systemd/user"
+	} else {
+		return "/etc/systemd/system"
+	}
+}
+
+// GenerateSystemdUnits generates the unit file of the host server, which resurrects the apps saved
+// by `ngrpc save` on boot, and the units of the apps if `options.Apps` is set, in which case the
+// apps are managed by systemd instead, and the host server runs in standalone mode.
+func GenerateSystemdUnits(conf config.Config, options SystemdOptions) ([]SystemdUnit, error) {
+	tsCfg, err := config.LoadTsConfig(conf.Tsconfig)
+
+	if err != nil {
+		tsCfg = config.TsConfig{}
+	}
+
+	executable := quoteUnitValue(options.Executable)
+	execStart := executable + " host-server --resurrect"
+	// Stop the apps gracefully before the host server is terminated.
+	execStop := executable + " stop"
+
+	if options.Apps {
+		// The apps are managed by their own units, so the host server must not revive or restart
+		// them.
+		execStart = executable + " host-server --standalone"
+		execStop = executable + " host --stop"
+	}
+
+	host := newSystemdUnit()
+	host.section("Unit")
+	host.set("Description", "NgRPC host server")
+	host.set("After", "network.target")
+	host.section("Service")
+	host.set("Type", "simple")
+	host.setService(options)
+	host.set("ExecStart", execStart)
+	host.set("ExecStop", execStop)
+	host.set("Restart", "on-failure")
+	host.setInstall(options)
+
+	units := []SystemdUnit{{
+		Name:      systemdHostUnit,
+		Content:   host.String(),
+		Instances: []string{systemdHostUnit},
+	}}
+
+	if !options.Apps {
+		return units, nil
+	}
+
+	apps := slicex.Filter(conf.Apps, func(app config.App, _ int) bool {
+		return app.Serve && app.Entry != ""
+	})
+	baseNames := slicex.Uniq(slicex.Map(apps, func(app config.App, _ int) string {
+		return config.GetBaseName(app.Name)
+	}))
+
+	for _, baseName := range baseNames {
+		replicas := slicex.Filter(apps, func(app config.App, _ int) bool {
+			return app.Name != baseName && config.GetBaseName(app.Name) == baseName
+		})
+		app, _ := slicex.Find(apps, func(app config.App, _ int) bool {
+			return config.GetBaseName(app.Name) == baseName
+		})
+		unit := generateAppUnit(app, baseName, len(replicas) > 0, apps, tsCfg, options)
+
+		if len(replicas) > 0 {
+			unit.Instances = slicex.Map(replicas, func(replica config.App, _ int) string {
+				return getAppUnitName(replica.Name)
+			})
+		} else {
+			unit.Instances = []string{unit.Name}
+		}
+
+		units = append(units, unit)
+	}
+
+	return units, nil
+}
+
+// GetSystemdInstallSteps returns the commands to install and enable the units written to `outDir`.
+func GetSystemdInstallSteps(units []SystemdUnit, options SystemdOptions, outDir string) []string {
+	unitDir := GetSystemdUnitDir(options.User)
+	files := slicex.Map(units, func(unit SystemdUnit, _ int) string {
+		return filepath.Join(outDir, unit.Name)
+	})
+	instances := slicex.Flat(slicex.Map(units, func(unit SystemdUnit, _ int) []string {
+		return unit.Instances
+	}))
+	steps := []string{}
+
+	if options.User {
+		steps = append(steps,
+			"mkdir -p "+unitDir,
+			"cp "+strings.Join(files, " ")+" "+unitDir+"/",
+			"systemctl --user daemon-reload",
+			"systemctl --user enable --now "+strings.Join(instances, " "),
+			// Without lingering, the user units only run while the user is logged in.
+			"sudo loginctl enable-linger "+options.RunAs,
+		)
+	} else {
+		steps = append(steps,
+			"sudo cp "+strings.Join(files, " ")+" "+unitDir+"/",
+			"sudo systemctl daemon-reload",
+			"sudo systemctl enable --now "+strings.Join(instances, " "),
+		)
+	}
+
+	return steps
+}
+
+func generateAppUnit(
+	app config.App,
+	baseName string,
+	isTemplate bool,
+	apps []config.App,
+	tsCfg config.TsConfig,
+	options SystemdOptions,
+) SystemdUnit {
+	// The app name is used as a string with specifiers, e.g. `%i` for the replica.
+	appName := escapeSpecifiers(baseName)
+	name := "ngrpc-" + escapeUnitName(baseName) + ".service"
+
+	if isTemplate {
+		appName += "#%i"
+		name = "ngrpc-" + escapeUnitName(baseName) + "@.service"
+	}
+
+	command, env := getAppCommand(app, tsCfg, options)
+	after := []string{"network.target", systemdHostUnit}
+
+	for _, dep := range app.DependsOn {
+		for _, item := range apps {
+			if config.MatchApp(item.Name, dep) && config.GetBaseName(item.Name) != baseName {
+				after = append(after, getAppUnitName(item.Name))
+			}
+		}
+	}
+
+	unit := newSystemdUnit()
+	unit.section("Unit")
+	unit.set("Description", fmt.Sprintf("NgRPC app [%s]", appName))
+	unit.set("After", strings.Join(slicex.Uniq(after), " "))
+	unit.set("Wants", systemdHostUnit)
+	unit.section("Service")
+
+	if filepath.Ext(app.Entry) == ".go" {
+		// Go apps notify systemd when they're ready.
+		unit.set("Type", "notify")
+	} else {
+		unit.set("Type", "simple")
+	}
+
+	unit.setService(options)
+
+	if filepath.Ext(app.Entry) == ".go" && !options.User && options.RunAs == "" {
+		// Without `User=`, systemd doesn't set `$HOME`, which `go build` needs for its caches.
+		unit.set("Environment", "HOME=%h")
+	}
+
+	keys := make([]string, 0, len(env))
+
+	for key := range env {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		unit.set("Environment", quoteUnitValue(key+"="+env[key]))
+	}
+
+	unit.set("ExecStart", strings.Join(append(command, quoteUnitString(appName)), " "))
+
+	if app.Stdout != "" {
+		stdout := getAbsPath(app.Stdout, options.WorkDir)
+		stderr := stdout
+
+		if app.Stderr != "" {
+			stderr = getAbsPath(app.Stderr, options.WorkDir)
+		}
+
+		unit.set("StandardOutput", "append:"+escapeSpecifiers(stdout))
+		unit.set("StandardError", "append:"+escapeSpecifiers(stderr))
+	}
+
+	if app.Restart == "never" {
+		unit.set("Restart", "no")
+	} else if app.Restart == "on-failure" {
+		unit.set("Restart", "on-failure")
+	} else {
+		unit.set("Restart", "always")
+	}
+
+	unit.setInstall(options)
+
+	return SystemdUnit{Name: name, Content: unit.String()}
+}
+
+// getAppCommand returns the command to run the app and the env it needs, like `startProcess()`
+// does, except that Go entries are run with `ngrpc exec`, since the built binaries are keyed by the
+// hash of the sources and change over time, it builds the entry and replaces itself with the binary,
+// so the binary is the main process of the unit.
+func getAppCommand(
+	app config.App,
+	tsCfg config.TsConfig,
+	options SystemdOptions,
+) ([]string, map[string]string) {
+	entry, env := resolveApp(app, tsCfg)
+	ext := filepath.Ext(entry)
+
+	lookPath := func(file string) string {
+		if path, err := exec.LookPath(file); err == nil {
+			if abs, err := filepath.Abs(path); err == nil {
+				return abs
+			}
+		}
+
+		return file
+	}
+
+	if ext == ".go" {
+		return []string{quoteUnitValue(options.Executable), "exec", quoteUnitValue(entry)}, env
+	} else if ext == ".js" {
+		return []string{
+			lookPath("node"), "-r", "source-map-support/register", quoteUnitValue(entry),
+		}, env
+	} else {
+		// systemd requires the executable to be an absolute path, or a plain name in the PATH.
+		return []string{quoteUnitValue(filepath.Join(options.WorkDir, entry))}, env
+	}
+}
+
+// getAppUnitName returns the unit name of the app (or a replica) used in the dependencies.
+func getAppUnitName(name string) string {
+	baseName := config.GetBaseName(name)
+
+	if baseName != name {
+		return "ngrpc-" + escapeUnitName(baseName) + "@" + name[len(baseName)+1:] + ".service"
+	} else {
+		return "ngrpc-" + escapeUnitName(name) + ".service"
+	}
+}
+
+func escapeUnitName(name string) string {
+	return invalidUnitChars.ReplaceAllString(name, "-")
+}
+
+// escapeSpecifiers escapes the `%` characters, which are specifiers in the unit files.
+func escapeSpecifiers(value string) string {
+	return strings.ReplaceAll(value, "%", "%%")
+}
+
+// quoteUnitValue escapes the specifiers in the value and quotes it if it contains spaces or quotes.
+func quoteUnitValue(value string) string {
+	return quoteUnitString(escapeSpecifiers(value))
+}
+
+// quoteUnitString is like `quoteUnitValue()`, except that the specifiers are kept.
+func quoteUnitString(value string) string {
+	if strings.ContainsAny(value, " \t\"'\\") {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, `"`, `\"`)
+		return `"` + value + `"`
+	}
+
+	return value
+}
+
+func getAbsPath(filename string, workDir string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+
+	return filepath.Join(workDir, filename)
+}
+
+// systemdUnitBuilder writes the sections and directives of a unit file.
+type systemdUnitBuilder struct {
+	builder strings.Builder
+}
+
+func newSystemdUnit() *systemdUnitBuilder {
+	unit := &systemdUnitBuilder{}
+	unit.builder.WriteString("# Generated by `ngrpc startup systemd`.\n")
+	return unit
+}
+
+func (self *systemdUnitBuilder) section(name string) {
+	self.builder.WriteString("\n[" + name + "]\n")
+}
+
+func (self *systemdUnitBuilder) set(key string, value string) {
+	self.builder.WriteString(key + "=" + value + "\n")
+}
+
+func (self *systemdUnitBuilder) setService(options SystemdOptions) {
+	self.set("WorkingDirectory", quoteUnitValue(options.WorkDir))
+
+	if !options.User && options.RunAs != "" {
+		self.set("User", options.RunAs)
+	}
+}
+
+func (self *systemdUnitBuilder) setInstall(options SystemdOptions) {
+	self.section("Install")
+
+	if options.User {
+		self.set("WantedBy", "default.target")
+	} else {
+		self.set("WantedBy", "multi-user.target")
+	}
+}
+
+func (self *systemdUnitBuilder) String() string {
+	return self.builder.String()
+}
@@ -0,0 +1,141 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"testing"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func getSystemdTestConfig() config.Config {
+	apps := goext.Ok(config.ExpandApps([]config.App{
+		{
+			Name:      "user-server",
+			Url:       "grpc://localhost:{4000+i}",
+			Serve:     true,
+			Instances: 2,
+			Entry:     "entry/main.go",
+			Stdout:    "out.log",
+			Stderr:    "/var/log/err.log",
+			Env:       map[string]string{"NAME": "a b", "RATE": "50%"},
+		},
+		{
+			Name:      "post-server",
+			Url:       "grpc://localhost:4002",
+			Serve:     true,
+			Entry:     "entry/post.sh",
+			DependsOn: []string{"user-server"},
+			Restart:   "never",
+		},
+		{
+			Name:  "client-app",
+			Url:   "grpc://localhost:4003",
+			Entry: "entry/client.go",
+		},
+	}))
+
+	return config.Config{Apps: apps}
+}
+
+func TestGenerateSystemdUnits(t *testing.T) {
+	options := SystemdOptions{
+		Executable: "/usr/local/bin/ngrpc",
+		WorkDir:    "/srv/app",
+		RunAs:      "deploy",
+	}
+	units := goext.Ok(GenerateSystemdUnits(getSystemdTestConfig(), options))
+
+	assert.Equal(t, 1, len(units))
+	assert.Equal(t, "ngrpc-host.service", units[0].Name)
+	assert.Equal(t, `# Generated by `+"`ngrpc startup systemd`"+`.
+
+[Unit]
+Description=NgRPC host server
+After=network.target
+
+[Service]
+Type=simple
+WorkingDirectory=/srv/app
+User=deploy
+ExecStart=/usr/local/bin/ngrpc host-server --resurrect
+ExecStop=/usr/local/bin/ngrpc stop
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`, units[0].Content)
+
+	assert.Equal(t, []string{
+		"sudo cp systemd/ngrpc-host.service /etc/systemd/system/",
+		"sudo systemctl daemon-reload",
+		"sudo systemctl enable --now ngrpc-host.service",
+	}, GetSystemdInstallSteps(units, options, "systemd"))
+}
+
+func TestGenerateSystemdUnits_apps(t *testing.T) {
+	options := SystemdOptions{
+		User:       true,
+		Apps:       true,
+		Executable: "/usr/local/bin/ngrpc",
+		WorkDir:    "/srv/app",
+		RunAs:      "deploy",
+	}
+	units := goext.Ok(GenerateSystemdUnits(getSystemdTestConfig(), options))
+
+	assert.Equal(t, 3, len(units))
+	assert.Contains(t, units[0].Content, "ExecStart=/usr/local/bin/ngrpc host-server --standalone\n")
+	assert.Contains(t, units[0].Content, "ExecStop=/usr/local/bin/ngrpc host --stop\n")
+	assert.NotContains(t, units[0].Content, "User=")
+	assert.Contains(t, units[0].Content, "WantedBy=default.target\n")
+
+	// The replicas share a template unit.
+	assert.Equal(t, "ngrpc-user-server@.service", units[1].Name)
+	assert.Equal(t, []string{"ngrpc-user-server@0.service", "ngrpc-user-server@1.service"},
+		units[1].Instances)
+	assert.Contains(t, units[1].Content, "Description=NgRPC app [user-server#%i]\n")
+	assert.Contains(t, units[1].Content, "Type=notify\n")
+	assert.NotContains(t, units[1].Content, "NotifyAccess=")
+	assert.NotContains(t, units[1].Content, "HOME=")
+	assert.Contains(t, units[1].Content, "Environment=\"NAME=a b\"\nEnvironment=RATE=50%%\n")
+	assert.Contains(t, units[1].Content,
+		"ExecStart=/usr/local/bin/ngrpc exec entry/main.go user-server#%i\n")
+	assert.Contains(t, units[1].Content, "StandardOutput=append:/srv/app/out.log\n")
+	assert.Contains(t, units[1].Content, "StandardError=append:/var/log/err.log\n")
+	assert.Contains(t, units[1].Content, "Restart=always\n")
+
+	assert.Equal(t, "ngrpc-post-server.service", units[2].Name)
+	assert.Contains(t, units[2].Content, "After=network.target ngrpc-host.service "+
+		"ngrpc-user-server@0.service ngrpc-user-server@1.service\n")
+	assert.Contains(t, units[2].Content, "ExecStart=/srv/app/entry/post.sh post-server\n")
+	assert.Contains(t, units[2].Content, "Type=simple\n")
+	assert.Contains(t, units[2].Content, "Restart=no\n")
+	assert.NotContains(t, units[2].Content, "StandardOutput=")
+
+	assert.Equal(t, []string{
+		"mkdir -p ~/.config/systemd/user",
+		"cp out/ngrpc-host.service out/ngrpc-user-server@.service " +
+			"out/ngrpc-post-server.service ~/.config/systemd/user/",
+		"systemctl --user daemon-reload",
+		"systemctl --user enable --now ngrpc-host.service ngrpc-user-server@0.service " +
+			"ngrpc-user-server@1.service ngrpc-post-server.service",
+		"sudo loginctl enable-linger deploy",
+	}, GetSystemdInstallSteps(units, options, "out"))
+}
+
+func TestGenerateSystemdUnits_appsWithoutUser(t *testing.T) {
+	options := SystemdOptions{
+		Apps:       true,
+		Executable: "/usr/local/bin/ngrpc",
+		WorkDir:    "/srv/app",
+	}
+	units := goext.Ok(GenerateSystemdUnits(getSystemdTestConfig(), options))
+
+	// `go build` needs `$HOME` for its caches.
+	assert.Contains(t, units[1].Content, "WorkingDirectory=/srv/app\nEnvironment=HOME=%h\n")
+	assert.NotContains(t, units[1].Content, "User=")
+	assert.NotContains(t, units[2].Content, "HOME=")
+}
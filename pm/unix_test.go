@@ -6,6 +6,7 @@ package pm
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/ayonli/ngrpc/config"
 	"github.com/ayonli/ngrpc/util"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 )
 
 func TestHost_WaitForExit(t *testing.T) {
@@ -27,16 +29,44 @@ func TestHost_WaitForExit(t *testing.T) {
 
 	go func() {
 		time.Sleep(time.Millisecond * 10) // wait a while for the host to start
-		assert.Equal(t, 1, host.state)
+		assert.Equal(t, int32(1), host.state.Load())
 		syscall.Kill(syscall.Getpid(), syscall.SIGINT)
 	}()
 
 	defer func() {
 		if re := recover(); re != nil {
-			assert.Equal(t, 0, host.state)
+			assert.Equal(t, int32(0), host.state.Load())
 			assert.Equal(t, "unexpected call to os.Exit(0) during test", fmt.Sprint(re))
 		}
 	}()
 
 	host.Start(true)
 }
+
+func TestLogWriter_RedirectStderr(t *testing.T) {
+	goext.Ok(0, os.MkdirAll("logs", 0755))
+	defer os.RemoveAll("logs")
+
+	// Restore the stderr of the test process afterwards.
+	fd := goext.Ok(syscall.Dup(int(os.Stderr.Fd())))
+	defer func() {
+		goext.Ok(0, unix.Dup2(fd, int(os.Stderr.Fd())))
+		syscall.Close(fd)
+	}()
+
+	writer := goext.Ok(NewLogWriter("host", "logs/host.log", nil))
+	writer.file.maxSize = 20 // bytes, for testing
+	goext.Ok(0, writer.RedirectStderr())
+
+	writer.Write([]byte("0123456789abcdef\n"))
+	fmt.Fprint(os.Stderr, "panic: first\n")
+	writer.Write([]byte("0123456789abcdef\n")) // rotates the file
+	fmt.Fprint(os.Stderr, "panic: second\n")
+	writer.Close()
+
+	files := listRotatedFiles(goext.Ok(filepath.Abs("logs/host.log")))
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, "0123456789abcdef\npanic: first\n", string(goext.Ok(os.ReadFile(files[0]))))
+	assert.Equal(t, "0123456789abcdef\npanic: second\n",
+		string(goext.Ok(os.ReadFile("logs/host.log"))))
+}
@@ -0,0 +1,139 @@
+package pm
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/pm/socket"
+)
+
+// Watcher subscribes to the host server for the membership changes of an app, every time an
+// instance of the app joins or leaves the group, the watcher receives the URLs of all the running
+// instances.
+type Watcher struct {
+	AppName  string
+	conn     net.Conn
+	onChange func(urls []string)
+	closed   bool
+	lock     sync.Mutex
+}
+
+// WatchApp creates a watcher for the app, `appName` can be either the base name or a specific
+// replica. `onChange` is called with the current URLs of the app immediately after the watcher is
+// connected, and again whenever the membership changes.
+//
+// If the host server is disconnected, the watcher keeps trying to reconnect in the background until
+// it's closed.
+func WatchApp(appName string, onChange func(urls []string)) (*Watcher, error) {
+	watcher := &Watcher{
+		AppName:  appName,
+		onChange: onChange,
+	}
+	err := watcher.connect()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+func (self *Watcher) connect() error {
+	_, sockPath := GetSocketPath()
+
+	if !IsHostOnline() {
+		return errors.New("host server is not running")
+	}
+
+	conn, err := socket.DialTimeout(sockPath, time.Second)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(EncodeMessage(ControlMessage{
+		Cmd:     CmdWatch,
+		App:     self.AppName,
+		MsgId:   newMsgId(),
+		Version: ProtocolVersion,
+	}))
+
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	self.lock.Lock()
+	self.conn = conn
+	self.lock.Unlock()
+
+	go func() {
+		packet := []byte{}
+		buf := make([]byte, 256)
+
+		for {
+			n, err := conn.Read(buf)
+
+			for _, msg := range DecodeMessage(&packet, buf[:n], err != nil) {
+				if msg.Cmd == CmdMembers {
+					self.onChange(slicex.Map(msg.Guests, func(item clientRecord, _ int) string {
+						return item.Url
+					}))
+				} else if msg.Cmd == CmdGoodbye {
+					conn.Close() // the host server is shutting down
+				} else if err := msg.Err(); err != nil {
+					// The watcher is rejected, e.g. the host server is of another version.
+					log.Println(err)
+					self.Close()
+				}
+			}
+
+			if err != nil {
+				conn.Close()
+				self.reconnect()
+				break
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (self *Watcher) reconnect() {
+	if self.isClosed() {
+		return
+	}
+
+	// The instances are unknown while the host is offline.
+	self.onChange([]string{})
+
+	for !self.isClosed() {
+		time.Sleep(time.Second)
+
+		if !self.isClosed() && self.connect() == nil {
+			break
+		}
+	}
+}
+
+func (self *Watcher) isClosed() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.closed
+}
+
+// Close stops watching and closes the connection to the host server.
+func (self *Watcher) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.closed = true
+
+	if self.conn != nil {
+		self.conn.Close()
+	}
+}
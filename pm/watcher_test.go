@@ -0,0 +1,81 @@
+//go:build !windows
+// +build !windows
+
+package pm
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchApp(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	goext.Ok(0, util.CopyFile("../tsconfig.json", "tsconfig.json"))
+	defer os.Remove("ngrpc.json")
+	defer os.Remove("tsconfig.json")
+
+	conf := goext.Ok(config.LoadConfig())
+	host := NewHost(conf, false)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	c := make(chan []string, 10)
+	watcher := goext.Ok(WatchApp("user-server", func(urls []string) {
+		c <- urls
+	}))
+	defer watcher.Close()
+
+	assert.Equal(t, []string{}, <-c)
+
+	guest1 := NewGuest(config.App{
+		Name: "user-server#0",
+		Url:  "grpc://localhost:34000",
+	}, func(msgId string) {})
+	guest1.Join()
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, 0, len(c)) // the instance is not reported until it's ready
+
+	guest1.Ready()
+	assert.Equal(t, []string{"grpc://localhost:34000"}, <-c)
+
+	guest2 := NewGuest(config.App{
+		Name: "user-server#1",
+		Url:  "grpc://localhost:34001",
+	}, func(msgId string) {})
+	guest2.Join()
+	guest2.Ready()
+	assert.Equal(t, []string{"grpc://localhost:34000", "grpc://localhost:34001"}, <-c)
+
+	guest3 := NewGuest(config.App{
+		Name: "post-server",
+		Url:  "grpc://localhost:34002",
+	}, func(msgId string) {})
+	guest3.Join()
+	guest3.Ready()
+	defer guest3.Leave("", "")
+
+	guest1.Leave("", "")
+	assert.Equal(t, []string{"grpc://localhost:34001"}, <-c)
+
+	guest2.Leave("", "")
+	assert.Equal(t, []string{}, <-c)
+
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, 0, len(c)) // post-server doesn't trigger any change
+}
+
+func TestWatchAppWhenNoHost(t *testing.T) {
+	goext.Ok(0, util.CopyFile("../ngrpc.json", "ngrpc.json"))
+	defer os.Remove("ngrpc.json")
+
+	watcher, err := WatchApp("user-server", func(urls []string) {})
+
+	assert.Nil(t, watcher)
+	assert.Equal(t, "host server is not running", err.Error())
+}
@@ -0,0 +1,87 @@
+package ngrpc
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/ayonli/goext/slicex"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/pm"
+	"google.golang.org/grpc/resolver"
+)
+
+// The service config used by the connections created by the `ngrpc:` resolver, which balances
+// traffic between the instances (replicas) of the app.
+const hostResolverServiceConfig = `{"loadBalancingConfig":[{"round_robin":{}}]}`
+
+// hostResolverBuilder builds resolvers for targets like `ngrpc://<app>` (or `ngrpc://<app>#<i>` for
+// a specific replica), the addresses of the app are resolved from the host server, and updated
+// whenever an instance of the app joins or leaves the group, so the connection follows restarts,
+// replicas and crashes automatically.
+type hostResolverBuilder struct{}
+
+func (self *hostResolverBuilder) Build(
+	target resolver.Target,
+	cc resolver.ClientConn,
+	opts resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	appName := target.URL.Host
+
+	if target.URL.Fragment != "" {
+		appName += "#" + target.URL.Fragment
+	}
+
+	if appName == "" {
+		return nil, fmt.Errorf("invalid target: %s", target.URL.String())
+	}
+
+	r := &hostResolver{appName: appName, cc: cc}
+	watcher, err := pm.WatchApp(appName, r.update)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.watcher = watcher
+	return r, nil
+}
+
+func (self *hostResolverBuilder) Scheme() string {
+	return "ngrpc"
+}
+
+type hostResolver struct {
+	appName string
+	cc      resolver.ClientConn
+	watcher *pm.Watcher
+}
+
+func (self *hostResolver) update(urls []string) {
+	addrs := []resolver.Address{}
+
+	slicex.ForEach(urls, func(rawUrl string, _ int) {
+		if urlObj, err := url.Parse(rawUrl); err == nil {
+			addrs = append(addrs, resolver.Address{Addr: config.GetAddress(urlObj)})
+		}
+	})
+
+	if len(addrs) == 0 {
+		self.cc.ReportError(fmt.Errorf("app [%s] is not running", self.appName))
+	} else {
+		self.cc.UpdateState(resolver.State{
+			Addresses:     addrs,
+			ServiceConfig: self.cc.ParseServiceConfig(hostResolverServiceConfig),
+		})
+	}
+}
+
+// ResolveNow is a no-op since the addresses are pushed by the host server.
+func (self *hostResolver) ResolveNow(opts resolver.ResolveNowOptions) {}
+
+func (self *hostResolver) Close() {
+	self.watcher.Close()
+}
+
+func init() {
+	resolver.Register(&hostResolverBuilder{})
+}
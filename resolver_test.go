@@ -0,0 +1,46 @@
+package ngrpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ayonli/goext"
+	"github.com/ayonli/ngrpc"
+	"github.com/ayonli/ngrpc/config"
+	"github.com/ayonli/ngrpc/pm"
+	"github.com/ayonli/ngrpc/services/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestHostResolver(t *testing.T) {
+	cfg := goext.Ok(config.LoadConfig())
+	host := pm.NewHost(cfg, true)
+	goext.Ok(0, host.Start(false))
+	defer host.Stop()
+
+	app := goext.Ok(ngrpc.StartWithConfig("example-server", config.Config{
+		Apps: []config.App{
+			{
+				Name:  "example-server",
+				Url:   "grpc://localhost:0",
+				Serve: true,
+				Services: []string{
+					"services.ExampleService",
+				},
+			},
+		},
+	}))
+	defer app.Stop()
+
+	conn := goext.Ok(grpc.Dial("ngrpc://example-server",
+		grpc.WithTransportCredentials(insecure.NewCredentials())))
+	defer conn.Close()
+
+	client := proto.NewExampleServiceClient(conn)
+	reply := goext.Ok(client.SayHello(context.Background(), &proto.HelloRequest{Name: "World"},
+		grpc.WaitForReady(true)))
+
+	assert.Equal(t, "Hello, World", reply.Message)
+}
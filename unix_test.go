@@ -5,10 +5,15 @@ package ngrpc_test
 
 import (
 	"fmt"
+	"net"
+	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/ayonli/goext"
 	"github.com/ayonli/ngrpc"
+	"github.com/ayonli/ngrpc/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,3 +32,41 @@ func TestWaitForExit(t *testing.T) {
 
 	app.WaitForExit()
 }
+
+func TestStartWithConfig_sdNotify(t *testing.T) {
+	// A local socket stands in for systemd.
+	sockPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn := goext.Ok(net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sockPath, Net: "unixgram"}))
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", sockPath)
+	t.Setenv("WATCHDOG_USEC", "40000")
+
+	read := func() string {
+		buf := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _ := conn.Read(buf)
+		return string(buf[:n])
+	}
+
+	cfg := config.Config{Apps: []config.App{{Name: "notify-app", Url: "grpc://localhost:4050"}}}
+	app := goext.Ok(ngrpc.StartWithConfig("notify-app", cfg))
+
+	assert.Equal(t, "READY=1", read())
+	assert.Equal(t, "WATCHDOG=1", read())
+	assert.Equal(t, "WATCHDOG=1", read())
+
+	app.Stop()
+
+	// The watchdog may have been pinged right before the app stopped.
+	state := read()
+
+	if state == "WATCHDOG=1" {
+		state = read()
+	}
+
+	assert.Equal(t, "STOPPING=1", state)
+
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	_, err := conn.Read(make([]byte, 64))
+	assert.NotNil(t, err) // no more pings
+}
@@ -0,0 +1,181 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression in the standard 5-field format
+// `<minute> <hour> <day of month> <month> <day of week>`, each field supports `*`, lists (`1,2`),
+// ranges (`1-5`) and steps (`*/5`, `1-30/2`). Months and weekdays can also be given by their names
+// (`JAN`, `MON`), and descriptors like `@daily` and `@hourly` are supported as well.
+type CronSchedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// If either the day of month or the day of week starts with `*`, both must match, otherwise,
+	// either of them matches.
+	anyDay bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT",
+	"NOV", "DEC"}
+var weekdayNames = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// ParseCron parses the cron expression.
+func ParseCron(expr string) (*CronSchedule, error) {
+	if descriptor, ok := cronDescriptors[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", expr)
+	}
+
+	schedule := &CronSchedule{}
+	specs := []struct {
+		bits  *uint64
+		min   int
+		max   int
+		names []string
+	}{
+		{&schedule.minutes, 0, 59, nil},
+		{&schedule.hours, 0, 23, nil},
+		{&schedule.days, 1, 31, nil},
+		{&schedule.months, 1, 12, monthNames},
+		{&schedule.weekdays, 0, 7, weekdayNames},
+	}
+
+	for i, spec := range specs {
+		bits, err := parseCronField(fields[i], spec.min, spec.max, spec.names)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+
+		*spec.bits = bits
+	}
+
+	if schedule.weekdays&(1<<7) != 0 { // both 0 and 7 stand for Sunday
+		schedule.weekdays |= 1
+	}
+
+	schedule.anyDay = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+
+	return schedule, nil
+}
+
+func parseCronField(field string, min int, max int, names []string) (uint64, error) {
+	var bits uint64
+
+	parseValue := func(str string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(str, name) {
+				return i + min, nil
+			}
+		}
+
+		value, err := strconv.Atoi(str)
+
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q", str)
+		} else if value < min || value > max {
+			return 0, fmt.Errorf("value %d out of range [%d, %d]", value, min, max)
+		}
+
+		return value, nil
+	}
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		start, end := min, max
+
+		if expr, stepStr, ok := strings.Cut(part, "/"); ok {
+			value, err := strconv.Atoi(stepStr)
+
+			if err != nil || value <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+
+			step = value
+			part = expr
+		}
+
+		if part != "*" {
+			startStr, endStr, isRange := strings.Cut(part, "-")
+			value, err := parseValue(startStr)
+
+			if err != nil {
+				return 0, err
+			}
+
+			start = value
+
+			if isRange {
+				if end, err = parseValue(endStr); err != nil {
+					return 0, err
+				} else if end < start {
+					return 0, fmt.Errorf("invalid range %q", part)
+				}
+			} else if step == 1 {
+				end = start
+			}
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << i
+		}
+	}
+
+	return bits, nil
+}
+
+// Next returns the next time after the given time that matches the schedule, or the zero time if
+// there is no such time within the next five years (e.g. `0 0 30 2 *`).
+func (self *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if self.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		} else if !self.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		} else if self.hours&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		} else if self.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (self *CronSchedule) matchDay(t time.Time) bool {
+	day := self.days&(1<<t.Day()) != 0
+	weekday := self.weekdays&(1<<int(t.Weekday())) != 0
+
+	if self.anyDay {
+		return day && weekday
+	} else {
+		return day || weekday
+	}
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronSchedule(t *testing.T) {
+	// 2026-01-02 is a Friday.
+	now := time.Date(2026, 1, 2, 10, 30, 15, 0, time.Local)
+	date := func(month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.Local)
+	}
+	next := func(expr string) time.Time {
+		return goext.Ok(ParseCron(expr)).Next(now)
+	}
+
+	assert.Equal(t, date(1, 2, 10, 31), next("* * * * *"))
+	assert.Equal(t, date(1, 2, 10, 45), next("*/15 * * * *"))
+	assert.Equal(t, date(1, 3, 3, 0), next("0 3 * * *"))
+	assert.Equal(t, date(1, 2, 12, 0), next("0 12,18 * * *"))
+	assert.Equal(t, date(1, 2, 11, 0), next("0 9-17 * * MON-FRI"))
+	assert.Equal(t, date(1, 5, 9, 0), next("0 9 * * 1-5/2"))
+	assert.Equal(t, date(1, 4, 0, 0), next("0 0 * * 7"))
+	assert.Equal(t, date(1, 4, 0, 0), next("@weekly"))
+	assert.Equal(t, date(2, 1, 0, 0), next("@monthly"))
+	assert.Equal(t, date(3, 15, 4, 0), next("0 4 15 mar *"))
+	// When both the day of month and the day of week are restricted, either of them matches.
+	assert.Equal(t, date(1, 3, 0, 0), next("0 0 10 * SAT"))
+	assert.True(t, next("0 0 30 2 *").IsZero())
+}
+
+func TestParseCron_error(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * foo *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+	} {
+		_, err := ParseCron(expr)
+		assert.NotNil(t, err, expr)
+	}
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SdNotify sends the state (e.g. `READY=1`) to the service manager via the socket specified by the
+// `NOTIFY_SOCKET` environment variable, see sd_notify(3). It reports `false` if the variable is not
+// set, which means the program is not run by a service manager that supports the protocol.
+func SdNotify(state string) (bool, error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+
+	if socketPath == "" {
+		return false, nil
+	} else if socketPath[0] == '@' {
+		socketPath = "\x00" + socketPath[1:] // abstract socket
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SdWatchdogTimeout returns the timeout of the service manager's watchdog for this process,
+// specified by the `WATCHDOG_USEC` (and `WATCHDOG_PID`) environment variable, see
+// sd_watchdog_enabled(3). It returns `0` if the watchdog is not enabled.
+func SdWatchdogTimeout() time.Duration {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+
+	if err != nil || usec <= 0 {
+		return 0
+	} else if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ayonli/goext"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	ok, err := SdNotify("READY=1")
+	assert.False(t, ok)
+	assert.Nil(t, err)
+
+	sockPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn := goext.Ok(net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sockPath, Net: "unixgram"}))
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", sockPath)
+
+	ok, err = SdNotify("READY=1")
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	buf := make([]byte, 64)
+	n := goext.Ok(conn.Read(buf))
+	assert.Equal(t, "READY=1", string(buf[:n]))
+
+	t.Setenv("NOTIFY_SOCKET", sockPath+".missing")
+	ok, err = SdNotify("READY=1")
+	assert.False(t, ok)
+	assert.NotNil(t, err)
+}
+
+func TestSdWatchdogTimeout(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	assert.Equal(t, time.Duration(0), SdWatchdogTimeout())
+
+	t.Setenv("WATCHDOG_USEC", "3000000")
+	assert.Equal(t, time.Second*3, SdWatchdogTimeout())
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	assert.Equal(t, time.Second*3, SdWatchdogTimeout())
+
+	// The watchdog is meant for another process.
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	assert.Equal(t, time.Duration(0), SdWatchdogTimeout())
+}
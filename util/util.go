@@ -1,6 +1,7 @@
 package util
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"hash/fnv"
@@ -9,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -122,3 +124,60 @@ func GetPidStat(pid int) (*pidusage.SysInfo, error) {
 		}
 	})
 }
+
+// TailFile returns the last `n` lines of the file.
+func TailFile(filename string, n int) ([]string, error) {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	stat, err := file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the file backwards chunk by chunk until one more line than needed is found, since log
+	// files could be very large.
+	const chunkSize = 64 * 1024
+	offset := stat.Size()
+	chunks := [][]byte{}
+	newlines := 0
+
+	for offset > 0 && newlines <= n {
+		start := max(offset-chunkSize, 0)
+		chunk := make([]byte, offset-start)
+
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		chunks = append(chunks, chunk)
+		newlines += bytes.Count(chunk, []byte{'\n'})
+		offset = start
+	}
+
+	slices.Reverse(chunks)
+	text := strings.TrimRight(string(bytes.Join(chunks, nil)), "\r\n")
+
+	if text == "" {
+		return []string{}, nil
+	}
+
+	lines := strings.Split(text, "\n")
+
+	if offset > 0 && len(lines) > 0 {
+		lines = lines[1:] // the first line may be incomplete
+	}
+
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return slicex.Map(lines, func(line string, _ int) string {
+		return strings.TrimRight(line, "\r")
+	}), nil
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/ayonli/goext"
@@ -70,3 +71,28 @@ func TestGetPidStat(t *testing.T) {
 	assert.True(t, stat.Memory > 0)
 	assert.True(t, stat.CPU >= 0.00)
 }
+
+func TestTailFile(t *testing.T) {
+	lines := []string{}
+
+	for i := 0; i < 100; i++ {
+		lines = append(lines, "line "+strconv.Itoa(i))
+	}
+
+	goext.Ok(0, os.WriteFile("test.log", []byte(strings.Join(lines, "\n")+"\n"), 0644))
+	defer os.Remove("test.log")
+
+	assert.Equal(t, []string{"line 97", "line 98", "line 99"}, goext.Ok(TailFile("test.log", 3)))
+	assert.Equal(t, lines, goext.Ok(TailFile("test.log", 200)))
+
+	// The lines longer than a chunk.
+	long := strings.Repeat("x", 100*1024)
+	goext.Ok(0, os.WriteFile("test.log", []byte(long+"\n"+long+"\nend\n"), 0644))
+	assert.Equal(t, []string{long, "end"}, goext.Ok(TailFile("test.log", 2)))
+
+	goext.Ok(0, os.WriteFile("test.log", []byte{}, 0644))
+	assert.Equal(t, []string{}, goext.Ok(TailFile("test.log", 3)))
+
+	_, err := TailFile("nonexistent.log", 3)
+	assert.True(t, os.IsNotExist(err))
+}